@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Builder loads the config and constructs the context and environment used to
+// render templates and exec the command. It may be called repeatedly to
+// rebuild them. The config is merged from ConfigFiles as by Config.ReadFiles
+// and the named Profiles are applied to it.
+// Templates are strict if either Strict or the config says so. ContextFiles
+// are loaded into the context before Vars and CLIContext. Vars are set by path
+// on the context rather than merged so that an index sets a single element of
+// an existing array. The types of untyped Vars and of nested environment
+// values are inferred if either InferTypes or the config says so.
+type Builder struct {
+	ConfigFiles  []string
+	Profiles     []string
+	ContextFiles []ContextFile
+	Vars         *MapVar
+	CLIContext   map[string]interface{}
+	Strict       bool
+	InferTypes   bool
+}
+
+// Build is the config, context, and environment constructed by a Builder.
+// Environ is the environment of the command after env_clear, env_keep, and
+// env_unset are applied while the `env` context holds every variable.
+// ConfigEnv holds the variables set by env_defaults and env.
+// Identity is the identity to exec the command as, or nil to keep the current
+// one. Renderer renders templates with the configured partials. Sources
+// records which source set each value in the context.
+type Build struct {
+	Config    *Config
+	ConfigEnv *Environ
+	Context   *Context
+	Environ   *Environ
+	Identity  *Identity
+	Renderer  *Renderer
+	Sources   ContextSources
+}
+
+// Config reads the config files and applies the profiles.
+func (b *Builder) Config() (*Config, error) {
+	config := &Config{}
+	if err := config.ReadFiles(b.ConfigFiles); err != nil {
+		return nil, err
+	}
+	if err := config.ApplyProfiles(b.Profiles); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// renderer returns the renderer for templates in the config.
+func (b *Builder) renderer(config *Config) *Renderer {
+	return &Renderer{
+		Partials: config.Partials,
+		Strict:   enabled(config.Strict) || b.Strict,
+	}
+}
+
+// Check reads the config and parses each of the templates and templated values
+// in it without rendering them. The context is not built so templated source
+// paths are not checked. All of the errors found are returned.
+func (b *Builder) Check() []error {
+	config, err := b.Config()
+	if err != nil {
+		return []error{err}
+	}
+	renderer := b.renderer(config)
+
+	errs := []error{}
+	checkStrings := func(name string, values ...string) {
+		for _, value := range values {
+			if _, err := renderer.ParseString(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: '%s': %s", name, value, err))
+			}
+		}
+	}
+	checkFile := func(file string) {
+		if _, err := renderer.ParseFile(file); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", file, err))
+		}
+	}
+
+	for _, tpl := range config.Templates {
+		checkStrings("templates", tpl.Src, tpl.Dst, tpl.SrcDir, tpl.DstDir, tpl.Owner, tpl.Group)
+		if tpl.Src != "" && !strings.Contains(tpl.Src, "{{") {
+			checkFile(tpl.Src)
+		}
+		if tpl.SrcDir != "" && !strings.Contains(tpl.SrcDir, "{{") {
+			if dirTemplates, err := DirTemplates(tpl.SrcDir, "", tpl.Glob, tpl.Suffix, Template{}); err == nil {
+				for _, dirTemplate := range dirTemplates {
+					checkFile(dirTemplate.Src)
+				}
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	checkStrings("env", config.Env...)
+	checkStrings("env_defaults", config.EnvDefaults...)
+	checkStrings("exec", config.Exec...)
+	checkStrings("reload_exec", config.ReloadExec...)
+	return errs
+}
+
+// Build reads the config and constructs the context and environment from the
+// config, the process environment, the system, and the command line.
+func (b *Builder) Build() (*Build, error) {
+	config, err := b.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var identity *Identity
+	if config.User != "" {
+		identity, err = LookupIdentity(config.User, config.Group, config.SupplementaryGroups)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	renderer := b.renderer(config)
+	environ := &Environ{}
+	environ.Load(os.Environ())
+	if enabled(config.EnvFileSuffix) {
+		if err := environ.LoadFiles(enabled(config.EnvFileUnset)); err != nil {
+			return nil, err
+		}
+	}
+	set := map[string]bool{}
+	setEnviron := func(values map[string]string) {
+		environ.Update(values)
+		for name := range values {
+			set[name] = true
+		}
+	}
+	if identity != nil {
+		setEnviron(identity.Environ())
+	}
+	context := &Context{}
+	sources := ContextSources{}
+	merger := &Merger{Strategies: config.Merge, Delete: true, Sources: sources}
+	update := func(source string, values map[string]interface{}) error {
+		merger.Source = source
+		if _, err := merger.Merge(*context, values); err != nil {
+			return fmt.Errorf("%s: %s", source, err)
+		}
+		return nil
+	}
+	updateFiles := func(files []ContextFile) error {
+		for _, file := range files {
+			if values, err := file.Load(); err == nil {
+				if err := update("file:"+file.Path, values); err != nil {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := update("config", config.Context); err != nil {
+		return nil, err
+	}
+	if err := updateFiles(config.ContextFiles); err != nil {
+		return nil, err
+	}
+	if err := update("env", map[string]interface{}{"env": environ.Context()}); err != nil {
+		return nil, err
+	}
+	inferTypes := enabled(config.InferTypes) || b.InferTypes
+	if config.EnvPrefix != "" {
+		if nested, err := environ.Nested(config.EnvPrefix); err == nil {
+			if inferTypes {
+				nested = InferValues(nested)
+			}
+			if err := update("env", nested); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	}
+	if len(config.Secrets.Dirs) > 0 {
+		if secrets, err := config.Secrets.Load(); err == nil {
+			if err := update("secrets", map[string]interface{}{"secrets": secrets}); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	}
+	if sys, err := System(); err == nil {
+		if err := update("sys", map[string]interface{}{"sys": sys}); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+	if err := updateFiles(b.ContextFiles); err != nil {
+		return nil, err
+	}
+	if b.Vars != nil {
+		if err := b.Vars.Apply(context.Map(), inferTypes); err != nil {
+			return nil, fmt.Errorf("cli: %s", err)
+		}
+		// record the values which the assignments leave set
+		applied := map[string]interface{}{}
+		if err := b.Vars.Apply(applied, inferTypes); err != nil {
+			return nil, fmt.Errorf("cli: %s", err)
+		}
+		sources.Record("cli", applied)
+	}
+	if err := update("cli", b.CLIContext); err != nil {
+		return nil, err
+	}
+
+	// check requirements before rendering anything except those on variables
+	// which are set by env_defaults or env. Those are checked once rendered
+	// and all failures are reported together.
+	envNames := []string{}
+	for _, envVar := range append(append([]string{}, config.EnvDefaults...), config.Env...) {
+		name, _ := ParseEnvVar(envVar)
+		envNames = append(envNames, name)
+	}
+	require, requireEnv := config.Require.SplitEnv(envNames)
+	failures := require.Failures(context.Map())
+
+	configEnv := &Environ{}
+	renderEnv := func() error {
+		for _, envDefault := range config.EnvDefaults {
+			name, value := ParseEnvVar(envDefault)
+			if _, ok := (*environ)[name]; ok {
+				continue
+			}
+			if rendered, err := renderer.RenderString(value, context.Map()); err == nil {
+				setEnviron(map[string]string{name: rendered})
+				configEnv.Update(map[string]string{name: rendered})
+			} else {
+				return fmt.Errorf("env_defaults: %s", err)
+			}
+			values := map[string]interface{}{"env": map[string]interface{}{name: (*environ)[name]}}
+			if err := update("env-defaults", values); err != nil {
+				return err
+			}
+		}
+		if renderedEnv, err := renderer.RenderStrings(config.Env, context.Map()); err == nil {
+			renderedEnviron := &Environ{}
+			renderedEnviron.Load(renderedEnv)
+			setEnviron(*renderedEnviron)
+			configEnv.Update(*renderedEnviron)
+			sources.Record("config-env", map[string]interface{}{"env": renderedEnviron.Context()})
+		} else {
+			return err
+		}
+		return context.Update(map[string]interface{}{"env": environ.Context()}, true)
+	}
+	if err := renderEnv(); err != nil {
+		// a failed render is likely caused by a missing value
+		if len(failures) > 0 {
+			return nil, requirementsError(failures)
+		}
+		return nil, err
+	}
+	failures = append(failures, requireEnv.Failures(context.Map())...)
+	if err := requirementsError(failures); err != nil {
+		return nil, err
+	}
+
+	clearEnv := enabled(config.EnvClear) || len(config.EnvKeep) > 0
+	execEnviron := environ.Filter(func(name string) bool {
+		if MatchEnvName(config.EnvUnset, name) {
+			return false
+		}
+		return !clearEnv || set[name] || MatchEnvName(config.EnvKeep, name)
+	})
+
+	return &Build{
+		Config:    config,
+		ConfigEnv: configEnv,
+		Context:   context,
+		Environ:   execEnviron,
+		Identity:  identity,
+		Renderer:  renderer,
+		Sources:   sources,
+	}, nil
+}
+
+// Args renders the exec args.
+func (b *Build) Args() ([]string, error) {
+	return b.Renderer.RenderStrings(b.Config.Exec, b.Context.Map())
+}
+
+// Templates renders the paths, owner, and group of each configured template.
+// Template directories are expanded into a template for each file.
+func (b *Build) Templates() ([]*Template, error) {
+	templates := make([]*Template, 0, len(b.Config.Templates))
+	for _, cfg := range b.Config.Templates {
+		values := []string{cfg.Src, cfg.Dst, cfg.SrcDir, cfg.DstDir, cfg.Owner, cfg.Group}
+		rendered, err := b.Renderer.RenderStrings(values, b.Context.Map())
+		if err != nil {
+			return nil, err
+		}
+		tpl := Template{
+			Src:      rendered[0],
+			Dst:      rendered[1],
+			Mode:     os.FileMode(cfg.Mode),
+			Owner:    rendered[4],
+			Group:    rendered[5],
+			DirMode:  os.FileMode(cfg.DirMode),
+			Renderer: b.Renderer,
+		}
+		if cfg.SrcDir == "" {
+			templates = append(templates, &tpl)
+		} else if dirTemplates, err := DirTemplates(rendered[2], rendered[3], cfg.Glob, cfg.Suffix, tpl); err == nil {
+			templates = append(templates, dirTemplates...)
+		} else {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
+
+// RenderTemplates renders all of the configured templates. It returns the
+// destinations of the templates which changed, including those rendered
+// before an error.
+func (b *Build) RenderTemplates() ([]string, error) {
+	templates, err := b.Templates()
+	if err != nil {
+		return nil, err
+	}
+	changed := []string{}
+	for _, tpl := range templates {
+		if tplChanged, err := tpl.Render(b.Context.Map()); err != nil {
+			return changed, err
+		} else if tplChanged {
+			changed = append(changed, tpl.Dst)
+		}
+	}
+	return changed, nil
+}
+
+// DryRun writes the rendered templates, the environment variables set by the
+// config, and exec args to `w` without writing any files. Inherited variables
+// are not written. Each section begins with a `==> name <==` header.
+// Template headers include the destination along with the source, mode,
+// owner, and group.
+func (b *Build) DryRun(w io.Writer) error {
+	args, err := b.Args()
+	if err != nil {
+		return err
+	}
+	templates, err := b.Templates()
+	if err != nil {
+		return err
+	}
+
+	for _, tpl := range templates {
+		attrs := []string{"from " + tpl.Src}
+		if tpl.Mode != 0 {
+			attrs = append(attrs, fmt.Sprintf("mode %04o", tpl.Mode))
+		}
+		if tpl.Owner != "" {
+			attrs = append(attrs, "owner "+tpl.Owner)
+		}
+		if tpl.Group != "" {
+			attrs = append(attrs, "group "+tpl.Group)
+		}
+		buf := &bytes.Buffer{}
+		if err := tpl.Execute(buf, b.Context.Map()); err != nil {
+			return err
+		}
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(w, "==> template %s (%s) <==\n", tpl.Dst, strings.Join(attrs, ", "))
+		buf.WriteTo(w)
+	}
+
+	env := []string{}
+	for name := range *b.ConfigEnv {
+		if value, ok := (*b.Environ)[name]; ok {
+			env = append(env, EncodeEnvVar(name, value))
+		}
+	}
+	sort.Strings(env)
+	fmt.Fprintln(w, "==> env <==")
+	for _, envVar := range env {
+		fmt.Fprintln(w, envVar)
+	}
+
+	fmt.Fprintln(w, "==> exec <==")
+	quoted := make([]string, len(args))
+	for n, arg := range args {
+		quoted[n] = fmt.Sprintf("%q", arg)
+	}
+	_, err = fmt.Fprintln(w, strings.Join(quoted, " "))
+	return err
+}
+
+// DumpContext writes the context to `w` as JSON or YAML. If `annotate` is true
+// each value is replaced by a map of the value and the source which set it.
+func (b *Build) DumpContext(w io.Writer, format string, annotate bool) error {
+	var value interface{} = b.Context.Map()
+	if annotate {
+		value = b.Sources.Annotate(b.Context.Map())
+	}
+	value = Normalize(value)
+
+	switch format {
+	case "json":
+		if data, err := json.MarshalIndent(value, "", "  "); err == nil {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+			return err
+		} else {
+			return err
+		}
+	case "yaml":
+		if data, err := yaml.Marshal(value); err == nil {
+			_, err = w.Write(data)
+			return err
+		} else {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	src := path.Join(tmp, "src")
+	dst := path.Join(tmp, "dst")
+	configFile := path.Join(tmp, "conman.yml")
+	config := `
+context:
+  greeting: Hello
+  conman_test:
+    db:
+      host: localhost
+      port: 5432
+  servers: [a, b]
+merge:
+  greeting: keep-first
+env_prefix: CONMAN_TEST
+templates:
+  ` + dst + `: ` + src + `
+env:
+- CONMAN_TEST_SUBJECT={{ .subject }}
+exec:
+- /bin/echo
+- '{{ .greeting }}, {{ .env.CONMAN_TEST_SUBJECT }}!'
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(src, []byte(`{{ .greeting }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("CONMAN_TEST__DB__HOST", "db")
+	defer os.Unsetenv("CONMAN_TEST__DB__HOST")
+
+	vars := &MapVar{}
+	for _, value := range []string{"conman_test.db.name=app", "conman_test.db.port=~delete", "servers[1]=x"} {
+		if err := vars.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	builder := &Builder{
+		ConfigFiles: []string{configFile},
+		Vars:        vars,
+		CLIContext:  map[string]interface{}{"subject": "world", "greeting": "Hi"},
+	}
+	build, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDb := map[string]interface{}{"host": "db", "name": "app"}
+	if have := build.Context.Map()["conman_test"].(map[string]interface{})["db"]; !reflect.DeepEqual(have, wantDb) {
+		t.Errorf("%+v != %+v", have, wantDb)
+	}
+	wantServers := []interface{}{"a", "x"}
+	if have := build.Context.Map()["servers"]; !reflect.DeepEqual(have, wantServers) {
+		t.Errorf("%+v != %+v", have, wantServers)
+	}
+
+	want := []string{"/bin/echo", "Hello, world!"}
+	if have, err := build.Args(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(have, want) {
+		t.Errorf("%+v != %+v", have, want)
+	}
+
+	// dry run doesn't write the template
+	buf := &bytes.Buffer{}
+	if err := build.DryRun(buf); err != nil {
+		t.Error(err)
+	}
+	for _, want := range []string{
+		"==> template " + dst + " (from " + src + ") <==\nHello\n==> env <==\n",
+		"\nCONMAN_TEST_SUBJECT=world\n",
+		"\n==> exec <==\n\"/bin/echo\" \"Hello, world!\"\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("dry run missing %q:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "CONMAN_TEST__DB__HOST") {
+		t.Errorf("dry run includes inherited environment:\n%s", buf.String())
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("%s exists", dst)
+	}
+
+	// dump the context with annotations
+	buf.Reset()
+	if err := build.DumpContext(buf, "json", true); err != nil {
+		t.Error(err)
+	}
+	var dumped map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &dumped); err != nil {
+		t.Error(err)
+	} else {
+		for _, test := range []struct {
+			path   []string
+			value  string
+			source string
+		}{
+			{[]string{"greeting"}, "Hello", "config"},
+			{[]string{"subject"}, "world", "cli"},
+			{[]string{"env", "CONMAN_TEST_SUBJECT"}, "world", "config-env"},
+		} {
+			var value interface{} = dumped
+			for _, key := range test.path {
+				value = value.(map[string]interface{})[key]
+			}
+			want := map[string]interface{}{"value": test.value, "source": test.source}
+			if !reflect.DeepEqual(value, want) {
+				t.Errorf("%v: %+v != %+v", test.path, value, want)
+			}
+		}
+	}
+
+	if changed, err := build.RenderTemplates(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(changed, []string{dst}) {
+		t.Errorf("%+v != [%s]", changed, dst)
+	}
+	if changed, err := build.RenderTemplates(); err != nil {
+		t.Error(err)
+	} else if len(changed) != 0 {
+		t.Errorf("%+v not empty", changed)
+	}
+}
+
+func TestBuilderCheck(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman-check-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	good := path.Join(tmp, "good.tpl")
+	bad := path.Join(tmp, "bad.tpl")
+	configFile := path.Join(tmp, "conman.yml")
+	config := `
+templates:
+  /tmp/good: ` + good + `
+  /tmp/bad: ` + bad + `
+  /tmp/{{ .name }}: '{{ .src }}'
+env:
+- A={{ .a }}
+- B={{ .b
+exec:
+- /bin/echo
+- '{{ if .x }}'
+`
+	for file, data := range map[string]string{
+		configFile: config,
+		good:       `{{ .greeting }}`,
+		bad:        `{{ range .items }}`,
+	} {
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	builder := &Builder{ConfigFiles: []string{configFile}}
+	errs := builder.Check()
+	if len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %d: %v", len(errs), errs)
+	}
+
+	builder = &Builder{ConfigFiles: []string{path.Join(tmp, "missing.yml")}}
+	if errs := builder.Check(); len(errs) != 1 {
+		t.Errorf("expected 1 error, got %d: %v", len(errs), errs)
+	}
+}
+
+func TestBuilderEnvDefaults(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman-env-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	configFile := path.Join(tmp, "conman.yml")
+	config := `
+context:
+  host: db.example.com
+env_defaults:
+- CONMAN_TEST_HOST={{ .host }}
+- CONMAN_TEST_URL=postgres://{{ .env.CONMAN_TEST_HOST }}/app
+- CONMAN_TEST_SET=default
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CONMAN_TEST_SET", "")
+	defer os.Unsetenv("CONMAN_TEST_SET")
+
+	builder := &Builder{ConfigFiles: []string{configFile}}
+	build, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"CONMAN_TEST_HOST": "db.example.com",
+		"CONMAN_TEST_URL":  "postgres://db.example.com/app",
+		"CONMAN_TEST_SET":  "",
+	}
+	env := build.Context.Map()["env"].(map[string]interface{})
+	for name, value := range want {
+		if have := (*build.Environ)[name]; have != value {
+			t.Errorf("environ %s: %q != %q", name, have, value)
+		}
+		if have := env[name]; have != value {
+			t.Errorf("context %s: %q != %q", name, have, value)
+		}
+	}
+}
+
+func TestBuilderEnvFilter(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman-env-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	configFile := path.Join(tmp, "conman.yml")
+	config := `
+env_keep:
+- CONMAN_TEST_KEEP_*
+env_unset:
+- CONMAN_TEST_KEEP_PASSWORD
+env_defaults:
+- CONMAN_TEST_DEFAULT=default
+env:
+- CONMAN_TEST_SET={{ .env.CONMAN_TEST_SECRET }}
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"CONMAN_TEST_SECRET":        "secret",
+		"CONMAN_TEST_KEEP_HOST":     "db",
+		"CONMAN_TEST_KEEP_PASSWORD": "password",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	builder := &Builder{ConfigFiles: []string{configFile}}
+	build, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Environ{
+		"CONMAN_TEST_KEEP_HOST": "db",
+		"CONMAN_TEST_DEFAULT":   "default",
+		"CONMAN_TEST_SET":       "secret",
+	}
+	if !reflect.DeepEqual(build.Environ, want) {
+		t.Errorf("%+v != %+v", build.Environ, want)
+	}
+	env := build.Context.Map()["env"].(map[string]interface{})
+	if env["CONMAN_TEST_SECRET"] != "secret" || env["CONMAN_TEST_KEEP_PASSWORD"] != "password" {
+		t.Error("filtered variables missing from context")
+	}
+}
+
+func TestBuilderRequire(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman-require-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	configFile := path.Join(tmp, "conman.yml")
+	config := `
+strict: true
+require:
+  env.CONMAN_TEST_DB_URL: URL of the database
+  env.CONMAN_TEST_DB: Database connection
+env_defaults:
+- CONMAN_TEST_DB={{ .env.CONMAN_TEST_DB_URL }}
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// requirements are checked before values are rendered
+	builder := &Builder{ConfigFiles: []string{configFile}}
+	want := "required values are not set:\n  env.CONMAN_TEST_DB_URL is required (URL of the database)"
+	if _, err := builder.Build(); err == nil {
+		t.Error("no error")
+	} else if err.Error() != want {
+		t.Errorf("%q != %q", err.Error(), want)
+	}
+
+	// requirements on rendered variables are checked once they are set
+	os.Setenv("CONMAN_TEST_DB_URL", "postgres://db/app")
+	defer os.Unsetenv("CONMAN_TEST_DB_URL")
+	if build, err := builder.Build(); err != nil {
+		t.Error(err)
+	} else if have := (*build.Environ)["CONMAN_TEST_DB"]; have != "postgres://db/app" {
+		t.Errorf("%q != %q", have, "postgres://db/app")
+	}
+
+	// failures before and after rendering are reported together
+	config = `
+require:
+  name: Application name
+  env.CONMAN_TEST_MODE: {values: [prod]}
+env:
+- CONMAN_TEST_MODE=dev
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err == nil {
+		t.Error("no error")
+	} else if lines := strings.Split(err.Error(), "\n"); len(lines) != 3 ||
+		!strings.Contains(lines[1], "name is required") || !strings.Contains(lines[2], "env.CONMAN_TEST_MODE") {
+		t.Errorf("missing failures: %s", err)
+	}
+}
+
+func TestBuildRenderTemplatesError(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman-render-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	good := path.Join(tmp, "good.tpl")
+	bad := path.Join(tmp, "bad.tpl")
+	configFile := path.Join(tmp, "conman.yml")
+	config := `
+templates:
+  ` + path.Join(tmp, "a.txt") + `: ` + good + `
+  ` + path.Join(tmp, "b.txt") + `: ` + bad + `
+`
+	for file, data := range map[string]string{
+		configFile: config,
+		good:       `good`,
+		bad:        `{{ range .items }}`,
+	} {
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	builder := &Builder{ConfigFiles: []string{configFile}}
+	build, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{path.Join(tmp, "a.txt")}
+	if changed, err := build.RenderTemplates(); err == nil {
+		t.Error("no error")
+	} else if !reflect.DeepEqual(changed, want) {
+		t.Errorf("%+v != %+v", changed, want)
+	}
+}
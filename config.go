@@ -1,20 +1,91 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Context   map[string]interface{} `yaml:"context"`
-	Templates map[string]string
-	Env       []string
-	Exec      []string
+	Context             map[string]interface{} `yaml:"context"`
+	ContextFiles        []ContextFile          `yaml:"context_files"`
+	Merge               MergeStrategies
+	Require             Requirements
+	Secrets             SecretsConfig
+	Templates           TemplateConfigs
+	Partials            []string
+	Strict              *bool
+	Env                 []string
+	EnvDefaults         []string `yaml:"env_defaults"`
+	EnvClear            *bool    `yaml:"env_clear"`
+	EnvKeep             []string `yaml:"env_keep"`
+	EnvUnset            []string `yaml:"env_unset"`
+	EnvFileSuffix       *bool    `yaml:"env_file_suffix"`
+	EnvFileUnset        *bool    `yaml:"env_file_unset"`
+	EnvPrefix           string   `yaml:"env_prefix"`
+	InferTypes          *bool    `yaml:"infer_types"`
+	Exec                []string
+	Init                *bool
+	InitGroup           *bool    `yaml:"init_group"`
+	ReloadSignal        string   `yaml:"reload_signal"`
+	ReloadExec          []string `yaml:"reload_exec"`
+	User                string
+	Group               string
+	SupplementaryGroups []string `yaml:"supplementary_groups"`
+	Profiles            map[string]*Config
 }
 
-// Load the configuration from the provided YAML data.
+// Load the configuration from the provided YAML data. Unknown keys and values
+// of the wrong type are errors.
 func (cfg *Config) Load(data []byte) error {
-	return yaml.Unmarshal(data, cfg)
+	if err := cfg.decode("", data); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+// decode checks the YAML data with CheckConfig and unmarshals it. Errors are
+// prefixed with `file` if it is set.
+func (cfg *Config) decode(file string, data []byte) error {
+	if err := CheckConfig(file, data); err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		if file == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration for errors.
+func (cfg *Config) Validate() error {
+	if cfg.ReloadSignal != "" {
+		if _, err := ParseSignal(cfg.ReloadSignal); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range append(append([]string{}, cfg.EnvKeep...), cfg.EnvUnset...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid env pattern %s: %s", pattern, err)
+		}
+	}
+	if cfg.User == "" && (cfg.Group != "" || cfg.SupplementaryGroups != nil) {
+		return errors.New("group and supplementary_groups require user")
+	}
+	for _, tpl := range cfg.Templates {
+		if err := tpl.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Read the configuration from the provided YAML file.
@@ -25,3 +96,276 @@ func (cfg *Config) Read(file string) error {
 		return err
 	}
 }
+
+// ReadFiles reads and merges the configuration from the provided YAML files.
+// A directory is replaced by the `*.yml` and `*.yaml` files in it in lexical
+// order. The merged configuration is validated once all files are read so
+// that a file may depend on values set in another.
+func (cfg *Config) ReadFiles(paths []string) error {
+	files, err := ConfigFiles(paths)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		fileCfg := &Config{}
+		if err := fileCfg.decode(file, data); err != nil {
+			return err
+		}
+		if err := cfg.Update(fileCfg); err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	return cfg.Validate()
+}
+
+// ConfigFiles expands each directory in `paths` to the `*.yml` and `*.yaml`
+// files in it sorted by name.
+func ConfigFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if !entry.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// Update merges `other` into the configuration. The context is merged using
+// the merge strategies of both configurations. Templates replace those with the
+// same destination and are otherwise appended. Env, env defaults, env keep and
+// unset patterns, partials, context files, secrets directories, requirements,
+// and merge strategies are concatenated with those from `other` taking
+// precedence. Profiles of the same name are merged. Any other value set in
+// `other`, including a boolean set to false, replaces the existing value.
+func (cfg *Config) Update(other *Config) error {
+	cfg.Merge = append(append(MergeStrategies{}, other.Merge...), cfg.Merge...)
+	if cfg.Context == nil {
+		cfg.Context = map[string]interface{}{}
+	}
+	merger := &Merger{Strategies: cfg.Merge, Delete: true}
+	if _, err := merger.Merge(cfg.Context, other.Context); err != nil {
+		return err
+	}
+
+	cfg.ContextFiles = append(cfg.ContextFiles, other.ContextFiles...)
+	cfg.Require = append(cfg.Require, other.Require...)
+	cfg.Secrets.Dirs = append(cfg.Secrets.Dirs, other.Secrets.Dirs...)
+	if other.Secrets.MaxSize != 0 {
+		cfg.Secrets.MaxSize = other.Secrets.MaxSize
+	}
+	cfg.Templates = cfg.Templates.Merge(other.Templates)
+	cfg.Partials = append(cfg.Partials, other.Partials...)
+	cfg.Env = append(cfg.Env, other.Env...)
+	cfg.EnvDefaults = append(cfg.EnvDefaults, other.EnvDefaults...)
+	cfg.EnvKeep = append(cfg.EnvKeep, other.EnvKeep...)
+	cfg.EnvUnset = append(cfg.EnvUnset, other.EnvUnset...)
+
+	mergeBool := func(dst **bool, src *bool) {
+		if src != nil {
+			*dst = src
+		}
+	}
+	mergeBool(&cfg.Secrets.Base64, other.Secrets.Base64)
+	mergeBool(&cfg.Strict, other.Strict)
+	mergeBool(&cfg.EnvClear, other.EnvClear)
+	mergeBool(&cfg.EnvFileSuffix, other.EnvFileSuffix)
+	mergeBool(&cfg.EnvFileUnset, other.EnvFileUnset)
+	mergeBool(&cfg.InferTypes, other.InferTypes)
+	mergeBool(&cfg.Init, other.Init)
+	mergeBool(&cfg.InitGroup, other.InitGroup)
+
+	mergeString := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	mergeString(&cfg.EnvPrefix, other.EnvPrefix)
+	mergeString(&cfg.ReloadSignal, other.ReloadSignal)
+	mergeString(&cfg.User, other.User)
+	mergeString(&cfg.Group, other.Group)
+
+	mergeStrings := func(dst *[]string, src []string) {
+		if src != nil {
+			*dst = src
+		}
+	}
+	mergeStrings(&cfg.Exec, other.Exec)
+	mergeStrings(&cfg.ReloadExec, other.ReloadExec)
+	mergeStrings(&cfg.SupplementaryGroups, other.SupplementaryGroups)
+
+	for name, profile := range other.Profiles {
+		if cfg.Profiles == nil {
+			cfg.Profiles = map[string]*Config{}
+		}
+		if existing, ok := cfg.Profiles[name]; ok {
+			if err := existing.Update(profile); err != nil {
+				return fmt.Errorf("profile %s: %s", name, err)
+			}
+		} else {
+			cfg.Profiles[name] = profile
+		}
+	}
+	return nil
+}
+
+// ApplyProfiles merges the named profiles into the configuration in order and
+// validates the result. Each profile is merged as a config file would be by
+// Update.
+func (cfg *Config) ApplyProfiles(names []string) error {
+	for _, name := range names {
+		profile, ok := cfg.Profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile %s", name)
+		}
+		if len(profile.Profiles) > 0 {
+			return fmt.Errorf("profile %s: profiles may not be nested", name)
+		}
+		if err := cfg.Update(profile); err != nil {
+			return fmt.Errorf("profile %s: %s", name, err)
+		}
+	}
+	return cfg.Validate()
+}
+
+// TemplateConfig describes a template to render. It is either a single file
+// given by Src and Dst, or a directory of templates given by SrcDir and DstDir.
+// Glob and Suffix filter the files rendered from a directory. All paths along
+// with the owner and group may be templated.
+type TemplateConfig struct {
+	Src     string
+	Dst     string
+	SrcDir  string `yaml:"src_dir"`
+	DstDir  string `yaml:"dst_dir"`
+	Glob    string
+	Suffix  string
+	Mode    FileMode
+	Owner   string
+	Group   string
+	DirMode FileMode `yaml:"dir_mode"`
+}
+
+// Validate checks that the template is either a file or a directory.
+func (t *TemplateConfig) Validate() error {
+	if t.SrcDir != "" || t.DstDir != "" {
+		if t.SrcDir == "" || t.DstDir == "" {
+			return errors.New("template directories require both src_dir and dst_dir")
+		} else if t.Src != "" || t.Dst != "" {
+			return fmt.Errorf("template directory %s may not have src or dst", t.SrcDir)
+		}
+	} else if t.Src == "" || t.Dst == "" {
+		return errors.New("templates require both src and dst")
+	} else if t.Glob != "" || t.Suffix != "" {
+		return fmt.Errorf("template %s may not have glob or suffix", t.Src)
+	}
+	return nil
+}
+
+// UnmarshalYAML accepts either a full template entry or a source path.
+func (t *TemplateConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var src string
+	if err := unmarshal(&src); err == nil {
+		t.Src = src
+		return nil
+	}
+	type plain TemplateConfig
+	return unmarshal((*plain)(t))
+}
+
+// TemplateConfigs is a list of templates to render. In YAML it may be a list
+// of template entries or a map of destinations to template entries. Map
+// entries are ordered by destination. The destination of a map entry with a
+// `src_dir` is its `dst_dir`.
+type TemplateConfigs []TemplateConfig
+
+// UnmarshalYAML accepts either a list of template entries or a map of
+// destinations to template entries.
+func (t *TemplateConfigs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []TemplateConfig
+	if err := unmarshal(&list); err == nil {
+		*t = list
+		return nil
+	}
+
+	entries := map[string]TemplateConfig{}
+	if err := unmarshal(&entries); err != nil {
+		return err
+	}
+	dsts := make([]string, 0, len(entries))
+	for dst := range entries {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+	*t = make([]TemplateConfig, 0, len(entries))
+	for _, dst := range dsts {
+		entry := entries[dst]
+		if entry.SrcDir == "" {
+			entry.Dst = dst
+		} else {
+			entry.DstDir = dst
+		}
+		*t = append(*t, entry)
+	}
+	return nil
+}
+
+// Merge returns the templates with those in `other` appended. A template in
+// `other` with the same destination as an existing template replaces it.
+func (t TemplateConfigs) Merge(other TemplateConfigs) TemplateConfigs {
+	merged := append(TemplateConfigs{}, t...)
+	for _, tpl := range other {
+		replaced := false
+		for n, existing := range merged {
+			if existing.Dst == tpl.Dst && existing.DstDir == tpl.DstDir {
+				merged[n] = tpl
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, tpl)
+		}
+	}
+	return merged
+}
+
+// enabled returns true if the option `b` is set and true.
+func enabled(b *bool) bool {
+	return b != nil && *b
+}
+
+// FileMode is a file mode which is given in YAML as an octal number or string.
+type FileMode os.FileMode
+
+// UnmarshalYAML parses the mode as an octal value.
+func (m *FileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	if mode, err := strconv.ParseUint(strings.TrimPrefix(value, "0o"), 8, 32); err == nil {
+		*m = FileMode(mode)
+		return nil
+	} else {
+		return fmt.Errorf("invalid file mode %s", value)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestConfigTemplates(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want TemplateConfigs
+	}{
+		{
+			yaml: `
+templates:
+  b.txt: b.tpl
+  a.txt: a.tpl
+`,
+			want: TemplateConfigs{
+				{Src: "a.tpl", Dst: "a.txt"},
+				{Src: "b.tpl", Dst: "b.txt"},
+			},
+		},
+		{
+			yaml: `
+templates:
+  secret.txt:
+    src: secret.tpl
+    mode: 0600
+    owner: app
+    group: app
+    dir_mode: "0750"
+`,
+			want: TemplateConfigs{
+				{Src: "secret.tpl", Dst: "secret.txt", Mode: 0600, Owner: "app", Group: "app", DirMode: 0750},
+			},
+		},
+		{
+			yaml: `
+templates:
+- src: b.tpl
+  dst: b.txt
+- src: a.tpl
+  dst: a.txt
+  mode: 0644
+`,
+			want: TemplateConfigs{
+				{Src: "b.tpl", Dst: "b.txt"},
+				{Src: "a.tpl", Dst: "a.txt", Mode: 0644},
+			},
+		},
+		{
+			yaml: `
+templates:
+  /etc/app:
+    src_dir: /etc/conman/app
+    suffix: .tpl
+  /etc/app.conf: app.tpl
+`,
+			want: TemplateConfigs{
+				{SrcDir: "/etc/conman/app", DstDir: "/etc/app", Suffix: ".tpl"},
+				{Src: "app.tpl", Dst: "/etc/app.conf"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		config := &Config{}
+		if err := config.Load([]byte(test.yaml)); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(config.Templates, test.want) {
+			t.Error("templates invalid")
+			t.Errorf("  have: %+v", config.Templates)
+			t.Errorf("  want: %+v", test.want)
+		}
+	}
+
+	for _, yaml := range []string{
+		"templates:\n  a.txt: {src: a.tpl, mode: rw}\n",
+		"templates:\n- src: a.tpl\n",
+		"templates:\n- src_dir: a\n",
+		"templates:\n- {src: a.tpl, dst: a.txt, suffix: .tpl}\n",
+	} {
+		config := &Config{}
+		if err := config.Load([]byte(yaml)); err == nil {
+			t.Errorf("no error: %s", yaml)
+		}
+	}
+}
+
+func TestConfigBool(t *testing.T) {
+	config := &Config{}
+	if err := config.Load([]byte("init: yes\nstrict: on\n")); err != nil {
+		t.Error(err)
+	} else if !enabled(config.Init) || !enabled(config.Strict) {
+		t.Errorf("booleans invalid: %+v", config)
+	}
+
+	// a later config changes only the booleans it sets, including to false
+	update := &Config{}
+	if err := update.Load([]byte("init: false\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Update(update); err != nil {
+		t.Error(err)
+	} else if enabled(config.Init) || !enabled(config.Strict) {
+		t.Errorf("booleans not updated: init=%v strict=%v", enabled(config.Init), enabled(config.Strict))
+	}
+
+	// YAML 1.1 booleans in the context are booleans rather than strings
+	config = &Config{}
+	data := "context: {ssl: off}\nprofiles:\n  dev:\n    context: {debug: yes}\n"
+	if err := config.Load([]byte(data)); err != nil {
+		t.Error(err)
+	} else if config.Context["ssl"] != false || config.Profiles["dev"].Context["debug"] != true {
+		t.Errorf("context booleans invalid: %+v %+v", config.Context, config.Profiles["dev"].Context)
+	}
+
+	config = &Config{}
+	if err := config.Load([]byte("init: maybe\n")); err == nil {
+		t.Error("no error: init: maybe")
+	}
+}
+
+func TestConfigContextFiles(t *testing.T) {
+	yaml := `
+context_files:
+- /etc/app/settings.json
+- path: /etc/app/db.env
+  key: db
+`
+	want := []ContextFile{
+		{Path: "/etc/app/settings.json"},
+		{Path: "/etc/app/db.env", Key: "db"},
+	}
+	config := &Config{}
+	if err := config.Load([]byte(yaml)); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(config.ContextFiles, want) {
+		t.Errorf("%+v != %+v", config.ContextFiles, want)
+	}
+}
+
+func TestConfigMerge(t *testing.T) {
+	yaml := `
+merge:
+  servers: unique-append
+  users:
+    strategy: merge-by-key
+    key: id
+  apps.*.env: replace
+`
+	config := &Config{}
+	if err := config.Load([]byte(yaml)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"apps.*.env:replace:", "servers:unique-append:", "users:merge-by-key:id"}
+	have := make([]string, len(config.Merge))
+	for n, strategy := range config.Merge {
+		have[n] = strategy.Path + ":" + strategy.Strategy + ":" + strategy.Key
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("%+v != %+v", have, want)
+	}
+
+	// a specific path takes precedence over a wildcard in the same file
+	yaml = `
+context:
+  apps: {web: {args: [a]}, api: {args: [a]}}
+merge:
+  apps.web.args: append
+  apps.*.args: replace
+`
+	config = &Config{}
+	if err := config.Load([]byte(yaml)); err != nil {
+		t.Fatal(err)
+	}
+	update := &Config{Context: map[string]interface{}{
+		"apps": map[string]interface{}{
+			"web": map[string]interface{}{"args": []interface{}{"b"}},
+			"api": map[string]interface{}{"args": []interface{}{"b"}},
+		},
+	}}
+	if err := config.Update(update); err != nil {
+		t.Fatal(err)
+	}
+	apps := Normalize(config.Context["apps"])
+	wantApps := map[string]interface{}{
+		"web": map[string]interface{}{"args": []interface{}{"a", "b"}},
+		"api": map[string]interface{}{"args": []interface{}{"b"}},
+	}
+	if !reflect.DeepEqual(apps, wantApps) {
+		t.Errorf("%+v != %+v", apps, wantApps)
+	}
+
+	for _, yaml := range []string{"merge: {servers: sideways}", "merge: {'servers[0]': replace}", "merge: {'a..b': replace}"} {
+		if err := (&Config{}).Load([]byte(yaml)); err == nil {
+			t.Errorf("%s: no error", yaml)
+		}
+	}
+}
+
+func TestConfigReadFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	confDir := path.Join(tmp, "conf.d")
+	if err := os.Mkdir(confDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		path.Join(tmp, "base.yml"): `
+context:
+  db:
+    host: localhost
+    port: 5432
+  servers: [a]
+merge:
+  servers: append
+templates:
+  /etc/app.conf: app.tpl
+  /etc/db.conf: db.tpl
+env:
+- A=1
+exec: [/bin/app]
+`,
+		path.Join(confDir, "20-prod.yml"): `
+context:
+  db:
+    host: db.example.com
+  servers: [c]
+user: app
+env:
+- C=3
+`,
+		path.Join(confDir, "10-group.yaml"): `
+context:
+  servers: [b]
+  db:
+    port: ~delete
+group: app
+templates:
+  /etc/app.conf: app-prod.tpl
+env:
+- B=2
+`,
+		path.Join(confDir, "README"): `not: [config`,
+	}
+	for file, data := range files {
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{}
+	if err := config.ReadFiles([]string{path.Join(tmp, "base.yml"), confDir}); err != nil {
+		t.Fatal(err)
+	}
+	wantContext := map[string]interface{}{
+		"db":      map[string]interface{}{"host": "db.example.com"},
+		"servers": []interface{}{"a", "b", "c"},
+	}
+	if !reflect.DeepEqual(config.Context, wantContext) {
+		t.Errorf("%+v != %+v", config.Context, wantContext)
+	}
+	wantTemplates := TemplateConfigs{
+		{Src: "app-prod.tpl", Dst: "/etc/app.conf"},
+		{Src: "db.tpl", Dst: "/etc/db.conf"},
+	}
+	if !reflect.DeepEqual(config.Templates, wantTemplates) {
+		t.Errorf("%+v != %+v", config.Templates, wantTemplates)
+	}
+	wantEnv := []string{"A=1", "B=2", "C=3"}
+	if !reflect.DeepEqual(config.Env, wantEnv) {
+		t.Errorf("%+v != %+v", config.Env, wantEnv)
+	}
+	if config.User != "app" || config.Group != "app" || !reflect.DeepEqual(config.Exec, []string{"/bin/app"}) {
+		t.Errorf("invalid config: %+v", config)
+	}
+
+	if err := config.ReadFiles([]string{path.Join(tmp, "missing.yml")}); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	yaml := `
+context:
+  level: info
+  db:
+    host: localhost
+env:
+- A=1
+exec: [/bin/app]
+strict: true
+profiles:
+  prod:
+    context:
+      db:
+        host: db.example.com
+    env:
+    - B=2
+  debug:
+    context:
+      level: debug
+    exec: [/bin/app, -debug]
+    strict: false
+`
+	config := &Config{}
+	if err := config.Load([]byte(yaml)); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.ApplyProfiles([]string{"prod", "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	wantContext := map[string]interface{}{
+		"level": "debug",
+		"db":    map[string]interface{}{"host": "db.example.com"},
+	}
+	if !reflect.DeepEqual(config.Context, wantContext) {
+		t.Errorf("%+v != %+v", config.Context, wantContext)
+	}
+	if want := []string{"A=1", "B=2"}; !reflect.DeepEqual(config.Env, want) {
+		t.Errorf("%+v != %+v", config.Env, want)
+	}
+	if want := []string{"/bin/app", "-debug"}; !reflect.DeepEqual(config.Exec, want) {
+		t.Errorf("%+v != %+v", config.Exec, want)
+	}
+	if enabled(config.Strict) {
+		t.Error("strict not turned off by profile")
+	}
+
+	if err := config.ApplyProfiles([]string{"staging"}); err == nil {
+		t.Error("no error")
+	}
+}
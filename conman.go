@@ -4,11 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"syscall"
 )
 
 const (
 	DefaultConfigFile = "/etc/conman.yml"
+	ProfileEnv        = "CONMAN_PROFILE"
 )
 
 func Fatalf(format string, v ...interface{}) {
@@ -16,78 +18,172 @@ func Fatalf(format string, v ...interface{}) {
 	os.Exit(1)
 }
 
+// Reload rebuilds the context and re-renders the templates. If any of the
+// templates changed the child is sent the reload signal or the reload command
+// is run. This happens even if a later template failed to render so that the
+// child sees the files which were written.
+func Reload(builder *Builder, supervisor *Supervisor) error {
+	build, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	changed, renderErr := build.RenderTemplates()
+	if len(changed) == 0 {
+		return renderErr
+	}
+	if err := notifyReload(build, supervisor); err != nil {
+		return err
+	}
+	return renderErr
+}
+
+// notifyReload runs the reload command or sends the reload signal to the child.
+func notifyReload(build *Build, supervisor *Supervisor) error {
+	if len(build.Config.ReloadExec) > 0 {
+		if args, err := build.Renderer.RenderStrings(build.Config.ReloadExec, build.Context.Map()); err == nil {
+			return supervisor.Spawn(args, build.Environ.Values())
+		} else {
+			return err
+		}
+	}
+
+	sig := syscall.SIGHUP
+	if build.Config.ReloadSignal != "" {
+		var err error
+		if sig, err = ParseSignal(build.Config.ReloadSignal); err != nil {
+			return err
+		}
+	}
+	return supervisor.Signal(sig)
+}
+
 func main() {
+	// parse the command, if any
+	command := ""
+	cliArgs := os.Args[1:]
+	if len(cliArgs) > 0 && !strings.HasPrefix(cliArgs[0], "-") {
+		command, cliArgs = cliArgs[0], cliArgs[1:]
+	}
+
 	// parse command line options
 	vars := MapVar{}
 	json := JsonVar{}
-	configFile := ""
+	yaml := YamlVar{&json}
+	files := ContextFileVar{}
+	configFiles := StringsVar{}
+	profiles := StringsVar{}
+	strict := false
+	dryRun := false
+	inferTypes := false
+	format := ""
+	annotate := false
 	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-	flags.StringVar(&configFile, "c", DefaultConfigFile, "Load configuration from this file.")
-	flags.Var(&vars, "v", "Add a value to the context. Formatted as `name=value`.")
-	flags.Var(&json, "j", "Add the contents of the JSON object to the context.")
-	flags.Parse(os.Args[1:])
-
-	// retrieve configuration
-	config := &Config{}
-	if err := config.Read(configFile); err != nil {
-		Fatalf("%s\n", err)
+	flags.Var(&configFiles, "c", "Load configuration from this file or directory. May be repeated. Defaults to "+DefaultConfigFile+".")
+	flags.Var(&profiles, "profile", "Apply the named config profile. May be repeated. Defaults to the comma separated profiles in $"+ProfileEnv+".")
+	flags.Var(&vars, "v", "Add a value to the context. Formatted as `name=value` or `name:type=value`.")
+	flags.Var(&json, "j", "Add the contents of the JSON object to the context. Formatted as `json`, `@path`, or `-` to read stdin.")
+	flags.Var(yaml, "y", "Add the contents of the YAML object to the context. Formatted as `yaml`, `@path`, or `-` to read stdin.")
+	flags.Var(&files, "f", "Add the contents of a JSON, YAML, TOML, .env, or .properties file to the context. Formatted as `[key=]path`.")
+	flags.BoolVar(&inferTypes, "infer", false, "Infer the types of untyped values set with -v.")
+	flags.BoolVar(&strict, "strict", false, "Fail to render templates which reference missing values.")
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the rendered templates, environment, and command instead of running.")
+	flags.StringVar(&format, "format", "yaml", "Output format of the context command. One of json or yaml.")
+	flags.BoolVar(&annotate, "annotate", false, "Annotate each value output by the context command with its source.")
+	flags.Parse(cliArgs)
+	if len(configFiles) == 0 {
+		configFiles = StringsVar{DefaultConfigFile}
+	}
+	if len(profiles) == 0 {
+		for _, name := range strings.Split(os.Getenv(ProfileEnv), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				profiles = append(profiles, name)
+			}
+		}
+	}
+
+	builder := &Builder{
+		ConfigFiles:  configFiles,
+		Profiles:     profiles,
+		ContextFiles: files.Files,
+		Vars:         &vars,
+		CLIContext:   json.Context,
+		Strict:       strict,
+		InferTypes:   inferTypes,
 	}
 
-	// build the environment and context
-	environ := &Environ{}
-	environ.Load(os.Environ())
-	context := &Context{}
-	context.Update(config.Context, false)
-	context.Update(map[string]interface{}{"env": environ.Context()}, false)
-	if sys, err := System(); err == nil {
-		context.Update(map[string]interface{}{"sys": sys}, false)
-	} else {
+	if command == "check" {
+		errs := builder.Check()
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// retrieve configuration and build the environment and context
+	build, err := builder.Build()
+	if err != nil {
 		Fatalf("%s\n", err)
 	}
-	cliCtx := &Context{}
-	cliCtx.Update(vars.Context, true)
-	cliCtx.Update(json.Context, true)
-	context.Update(cliCtx.Map(), false)
 
-	renderedEnv := make([]string, len(config.Env))
-	for n, envVar := range config.Env {
-		if renderedEnvVar, err := RenderString(envVar, context.Map()); err == nil {
-			renderedEnv[n] = renderedEnvVar
-		} else {
+	switch command {
+	case "":
+	case "context":
+		if err := build.DumpContext(os.Stdout, format, annotate); err != nil {
 			Fatalf("%s\n", err)
 		}
+		return
+	default:
+		Fatalf("unknown command %s\n", command)
 	}
-	environ.Load(renderedEnv)
-	context.Update(map[string]interface{}{"env": environ.Context()}, true)
 
-	// render the exec args
-	args := make([]string, len(config.Exec))
-	for n, arg := range config.Exec {
-		if renderedArg, err := RenderString(arg, context.Map()); err == nil {
-			args[n] = renderedArg
-		} else {
+	if dryRun {
+		if err := build.DryRun(os.Stdout); err != nil {
 			Fatalf("%s\n", err)
 		}
+		return
+	}
+
+	// render the exec args
+	args, err := build.Args()
+	if err != nil {
+		Fatalf("%s\n", err)
 	}
 
 	// render the templates
-	for dst, src := range config.Templates {
-		renderedDst, err := RenderString(dst, context.Map())
-		if err != nil {
-			Fatalf("%s\n", err)
+	if _, err := build.RenderTemplates(); err != nil {
+		Fatalf("%s\n", err)
+	}
+
+	// exec the command
+	if len(args) > 0 && enabled(build.Config.Init) {
+		supervisor := &Supervisor{
+			Args:  args,
+			Env:   build.Environ.Values(),
+			Group: enabled(build.Config.InitGroup),
 		}
-		renderedSrc, err := RenderString(src, context.Map())
-		if err != nil {
-			Fatalf("%s\n", err)
+		if build.Identity != nil {
+			supervisor.Credential = build.Identity.Credential()
+		}
+		if len(build.Config.Templates) > 0 {
+			supervisor.Reload = func() error {
+				return Reload(builder, supervisor)
+			}
 		}
-		if err := (&Template{renderedSrc, renderedDst}).Render(context.Map()); err != nil {
+		if status, err := supervisor.Run(); err == nil {
+			os.Exit(status)
+		} else {
 			Fatalf("%s\n", err)
 		}
-	}
-
-	// exec the command
-	if len(args) > 0 {
-		if err := syscall.Exec(args[0], args, environ.Values()); err != nil {
+	} else if len(args) > 0 {
+		if build.Identity != nil {
+			if err := build.Identity.Apply(); err != nil {
+				Fatalf("%s\n", err)
+			}
+		}
+		if err := syscall.Exec(args[0], args, build.Environ.Values()); err != nil {
 			Fatalf("%s\n", err)
 		}
 	}
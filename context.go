@@ -5,11 +5,104 @@ type Context map[string]interface{}
 
 // Update the context with additional content. This works by merging the new
 // tree of values with the existing context tree.
-func (c *Context) Update(values map[string]interface{}, appendArray bool) {
-	Merge(*c, values, appendArray)
+func (c *Context) Update(values map[string]interface{}, appendArray bool) error {
+	_, err := Merge(*c, values, appendArray)
+	return err
 }
 
 // Map returns the context as a map suitable for template rendering.
 func (c *Context) Map() map[string]interface{} {
 	return map[string]interface{}(*c)
 }
+
+// ContextSources records which source last set each value in a context. It
+// mirrors the structure of the context with the name of a source in place of
+// each value.
+type ContextSources map[string]interface{}
+
+// Record marks each value in `values` as set by `source`. Nested maps are
+// recorded recursively.
+func (s ContextSources) Record(source string, values map[string]interface{}) {
+	for key, value := range values {
+		if valueMap, ok := mapify(value); ok {
+			sub, ok := s[key].(ContextSources)
+			if !ok {
+				sub = ContextSources{}
+				s[key] = sub
+			}
+			sub.Record(source, valueMap)
+		} else {
+			s[key] = source
+		}
+	}
+}
+
+// Set records `source` as having set `value` at `path`. Sources recorded below
+// `path` are replaced.
+func (s ContextSources) Set(path []string, source string, value interface{}) {
+	parent, key := s.at(path[:len(path)-1]), path[len(path)-1]
+	if valueMap, ok := mapify(value); ok {
+		sub := ContextSources{}
+		sub.Record(source, valueMap)
+		parent[key] = sub
+	} else {
+		parent[key] = source
+	}
+}
+
+// Delete removes the sources recorded at `path`.
+func (s ContextSources) Delete(path []string) {
+	delete(s.at(path[:len(path)-1]), path[len(path)-1])
+}
+
+// at returns the sources of the map at `path`. Maps along the path are
+// created as needed.
+func (s ContextSources) at(path []string) ContextSources {
+	for _, key := range path {
+		sub, ok := s[key].(ContextSources)
+		if !ok {
+			sub = ContextSources{}
+			s[key] = sub
+		}
+		s = sub
+	}
+	return s
+}
+
+// Annotate returns a copy of `context` with each value replaced by a map
+// containing the value and the source which set it.
+func (s ContextSources) Annotate(context map[string]interface{}) map[string]interface{} {
+	annotated := make(map[string]interface{}, len(context))
+	for key, value := range context {
+		if valueMap, ok := mapify(value); ok {
+			sub, _ := s[key].(ContextSources)
+			annotated[key] = sub.Annotate(valueMap)
+		} else {
+			source, _ := s[key].(string)
+			annotated[key] = map[string]interface{}{
+				"value":  value,
+				"source": source,
+			}
+		}
+	}
+	return annotated
+}
+
+// Normalize recursively converts maps in `value` to map[string]interface{} and
+// slices to []interface{} so that it may be encoded as JSON.
+func Normalize(value interface{}) interface{} {
+	if _, ok := value.([]byte); ok {
+		return value
+	} else if m, ok := mapify(value); ok {
+		for key, item := range m {
+			m[key] = Normalize(item)
+		}
+		return m
+	} else if a, ok := arrayify(value); ok {
+		for n, item := range a {
+			a[n] = Normalize(item)
+		}
+		return a
+	}
+	return value
+}
@@ -17,7 +17,9 @@ func TestContext(t *testing.T) {
 	in := map[string]interface{}{"a": "aye", "b": "bee"}
 	want := map[string]interface{}{"a": "aye", "b": "bee"}
 	c = &Context{}
-	c.Update(in, true)
+	if err := c.Update(in, true); err != nil {
+		t.Error(err)
+	}
 	have = c.Map()
 	if !reflect.DeepEqual(have, want) {
 		t.Error("maps are not equal")
@@ -28,8 +30,12 @@ func TestContext(t *testing.T) {
 	update := map[string]interface{}{"a": "eh"}
 	want = map[string]interface{}{"a": "eh", "b": "bee"}
 	c = &Context{}
-	c.Update(in, true)
-	c.Update(update, true)
+	if err := c.Update(in, true); err != nil {
+		t.Error(err)
+	}
+	if err := c.Update(update, true); err != nil {
+		t.Error(err)
+	}
 	have = c.Map()
 	if !reflect.DeepEqual(have, want) {
 		t.Error("maps are not equal")
@@ -50,10 +56,68 @@ func TestContext(t *testing.T) {
 		"c": []interface{}{"see", "sea"},
 	}
 	c = &Context{}
-	c.Update(in, true)
-	c.Update(update, true)
+	if err := c.Update(in, true); err != nil {
+		t.Error(err)
+	}
+	if err := c.Update(update, true); err != nil {
+		t.Error(err)
+	}
 	have = c.Map()
 	if !reflect.DeepEqual(have, want) {
 		t.Error("maps are not equal")
 	}
 }
+
+func TestContextSources(t *testing.T) {
+	s := ContextSources{}
+	s.Record("config", map[string]interface{}{
+		"a": "aye",
+		"b": map[interface{}]interface{}{"x": 1, "y": 2},
+		"c": map[string]interface{}{"z": 3},
+	})
+	s.Record("cli", map[string]interface{}{
+		"b": map[string]interface{}{"y": 3},
+		"c": "see",
+	})
+
+	context := map[string]interface{}{
+		"a": "aye",
+		"b": map[string]interface{}{"x": 1, "y": 3},
+		"c": "see",
+		"d": "dee",
+	}
+	want := map[string]interface{}{
+		"a": map[string]interface{}{"value": "aye", "source": "config"},
+		"b": map[string]interface{}{
+			"x": map[string]interface{}{"value": 1, "source": "config"},
+			"y": map[string]interface{}{"value": 3, "source": "cli"},
+		},
+		"c": map[string]interface{}{"value": "see", "source": "cli"},
+		"d": map[string]interface{}{"value": "dee", "source": ""},
+	}
+	have := s.Annotate(context)
+	if !reflect.DeepEqual(have, want) {
+		t.Error("annotations are not equal")
+		t.Errorf("  have: %+v", have)
+		t.Errorf("  want: %+v", want)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	in := map[interface{}]interface{}{
+		"a": []interface{}{map[interface{}]interface{}{"b": "bee"}},
+		"c": []string{"see"},
+		"d": []byte("dee"),
+	}
+	want := map[string]interface{}{
+		"a": []interface{}{map[string]interface{}{"b": "bee"}},
+		"c": []interface{}{"see"},
+		"d": []byte("dee"),
+	}
+	have := Normalize(in)
+	if !reflect.DeepEqual(have, want) {
+		t.Error("values are not equal")
+		t.Errorf("  have: %+v", have)
+		t.Errorf("  want: %+v", want)
+	}
+}
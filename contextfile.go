@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ContextFile is a structured file which is loaded into the context. The
+// values in the file are placed under Key if it is set.
+type ContextFile struct {
+	Path string
+	Key  string
+}
+
+// UnmarshalYAML accepts either a full context file entry or a path.
+func (f *ContextFile) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		f.Path = path
+		return nil
+	}
+	type plain ContextFile
+	return unmarshal((*plain)(f))
+}
+
+// Load reads and parses the file. The format is determined by the file
+// extension: `.json`, `.yml` or `.yaml`, `.toml`, `.env`, or `.properties`.
+func (f *ContextFile) Load() (map[string]interface{}, error) {
+	wrapError := func(err error) error {
+		return fmt.Errorf("%s: %s", f.Path, err)
+	}
+
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	ext := strings.ToLower(filepath.Ext(f.Path))
+	switch ext {
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".yml", ".yaml":
+		var raw interface{}
+		if err = yaml.Unmarshal(data, &raw); err == nil && raw != nil {
+			var ok bool
+			if values, ok = Normalize(raw).(map[string]interface{}); !ok {
+				err = errors.New("not a map")
+			}
+		}
+	case ".toml":
+		_, err = toml.Decode(string(data), &values)
+	case ".env":
+		values, err = ParseDotenv(data)
+	case ".properties":
+		values, err = ParseProperties(data)
+	default:
+		err = fmt.Errorf("unknown file type %s", ext)
+	}
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	if f.Key != "" {
+		values = map[string]interface{}{f.Key: values}
+	}
+	return values, nil
+}
+
+// ParseDotenv parses a .env file. Lines are formatted as `NAME=VALUE` and may
+// be prefixed with `export`. Values may be single quoted, which are taken
+// literally, or double quoted, which support backslash escapes. Blank lines and
+// lines beginning with `#` are ignored as are comments following an unquoted
+// value.
+func ParseDotenv(data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		parts := strings.SplitN(line, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("line %d: expected NAME=VALUE", n)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch {
+		case strings.HasPrefix(value, "'"):
+			if end := strings.Index(value[1:], "'"); end >= 0 {
+				value = value[1 : end+1]
+			} else {
+				return nil, fmt.Errorf("line %d: unterminated quote", n)
+			}
+		case strings.HasPrefix(value, `"`):
+			if end := closingQuote(value); end > 0 {
+				unquoted, err := strconv.Unquote(value[:end+1])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", n, err)
+				}
+				value = unquoted
+			} else {
+				return nil, fmt.Errorf("line %d: unterminated quote", n)
+			}
+		default:
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = strings.TrimSpace(value[:comment])
+			}
+		}
+		values[name] = value
+	}
+	return values, scanner.Err()
+}
+
+// closingQuote returns the index of the double quote which closes the quote
+// at the start of `value`, or -1 if there is none.
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// ParseProperties parses a Java properties file. Keys are separated from
+// values by `=`, `:`, or whitespace. Lines beginning with `#` or `!` are
+// comments and lines ending in a backslash are continued on the next line.
+func ParseProperties(data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	logical := ""
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical == "" && (line == "" || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+		if strings.HasSuffix(line, `\`) && !strings.HasSuffix(line, `\\`) {
+			logical += line[:len(line)-1]
+			continue
+		}
+		logical += line
+
+		key, value := splitProperty(logical)
+		values[unescapeProperty(key)] = unescapeProperty(value)
+		logical = ""
+	}
+	if logical != "" {
+		key, value := splitProperty(logical)
+		values[unescapeProperty(key)] = unescapeProperty(value)
+	}
+	return values, scanner.Err()
+}
+
+// splitProperty splits a property line into its key and value.
+func splitProperty(line string) (string, string) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '=', ':':
+			return line[:i], strings.TrimLeft(line[i+1:], " \t\f")
+		case ' ', '\t', '\f':
+			rest := strings.TrimLeft(line[i:], " \t\f")
+			if strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ":") {
+				rest = strings.TrimLeft(rest[1:], " \t\f")
+			}
+			return line[:i], rest
+		}
+	}
+	return line, ""
+}
+
+// unescapeProperty replaces the escape sequences in a property key or value.
+func unescapeProperty(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			buf.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 't':
+			buf.WriteByte('\t')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'u':
+			if i+4 < len(value) {
+				if r, err := strconv.ParseUint(value[i+1:i+5], 16, 32); err == nil {
+					buf.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			buf.WriteByte('u')
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestContextFileLoad(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	want := map[string]interface{}{
+		"name": "app",
+		"db":   map[string]interface{}{"host": "localhost"},
+	}
+	flat := map[string]interface{}{"name": "app", "db.host": "localhost"}
+	files := []struct {
+		name    string
+		content string
+		want    map[string]interface{}
+	}{
+		{"app.json", `{"name": "app", "db": {"host": "localhost"}}`, want},
+		{"app.yml", "name: app\ndb:\n  host: localhost\n", want},
+		{"app.yaml", "name: app\ndb:\n  host: localhost\n", want},
+		{"app.toml", "name = \"app\"\n[db]\nhost = \"localhost\"\n", want},
+		{"app.env", "name=app\ndb.host=localhost\n", flat},
+		{".env", "name=app\ndb.host=localhost\n", flat},
+		{"app.properties", "name=app\ndb.host=localhost\n", flat},
+		{"empty.yml", "", map[string]interface{}{}},
+		{"ports.yml", "ports:\n  80: http\n  443: https\n", map[string]interface{}{
+			"ports": map[string]interface{}{"80": "http", "443": "https"},
+		}},
+	}
+
+	for _, file := range files {
+		filePath := path.Join(tmp, file.name)
+		if err := ioutil.WriteFile(filePath, []byte(file.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if have, err := (&ContextFile{Path: filePath}).Load(); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(have, file.want) {
+			t.Errorf("%s: %+v != %+v", file.name, have, file.want)
+		}
+	}
+
+	// load under a key
+	keyed := map[string]interface{}{"app": want}
+	if have, err := (&ContextFile{Path: path.Join(tmp, "app.json"), Key: "app"}).Load(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(have, keyed) {
+		t.Errorf("%+v != %+v", have, keyed)
+	}
+
+	// errors
+	invalid := map[string]string{
+		"bad.json": `{"name"`,
+		"list.yml": "- a\n- b\n",
+		"bad.env":  "NAME\n",
+		"app.txt":  "name=app\n",
+	}
+	for name, content := range invalid {
+		filePath := path.Join(tmp, name)
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&ContextFile{Path: filePath}).Load(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := (&ContextFile{Path: path.Join(tmp, "nope.json")}).Load(); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	data := `
+# a comment
+A=aye
+export B = bee
+C='single # quoted\n'
+D="double \"quoted\"\n" # comment
+E=unquoted # comment
+F=
+`
+	want := map[string]interface{}{
+		"A": "aye",
+		"B": "bee",
+		"C": `single # quoted\n`,
+		"D": "double \"quoted\"\n",
+		"E": "unquoted",
+		"F": "",
+	}
+	if have, err := ParseDotenv([]byte(data)); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(have, want) {
+		t.Error("values invalid")
+		t.Errorf("  have: %+v", have)
+		t.Errorf("  want: %+v", want)
+	}
+
+	for _, data := range []string{"A='aye\n", "A=\"aye\n", "=aye\n"} {
+		if _, err := ParseDotenv([]byte(data)); err == nil {
+			t.Errorf("no error: %q", data)
+		}
+	}
+}
+
+func TestParseProperties(t *testing.T) {
+	data := `
+# a comment
+! another comment
+a=aye
+b : bee
+c see
+d = multi \
+    line
+e\ key=tab\there
+f=A
+g
+`
+	want := map[string]interface{}{
+		"a":     "aye",
+		"b":     "bee",
+		"c":     "see",
+		"d":     "multi line",
+		"e key": "tab\there",
+		"f":     "A",
+		"g":     "",
+	}
+	if have, err := ParseProperties([]byte(data)); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(have, want) {
+		t.Error("values invalid")
+		t.Errorf("  have: %+v", have)
+		t.Errorf("  want: %+v", want)
+	}
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
+const (
+	FileSuffix      = "_FILE"
+	NestedSeparator = "__"
+)
+
 // Environ is a collection of environment variables. It does not maintain the
 // order of its values.
 type Environ map[string]string
@@ -30,6 +39,36 @@ func (env *Environ) Load(environ []string) {
 	env.Update(envMap)
 }
 
+// LoadFiles sets each variable `NAME` for which a `NAME_FILE` variable exists to
+// the contents of the file named by `NAME_FILE`. Trailing newlines are removed
+// from the contents. It is an error for both `NAME` and `NAME_FILE` to be set.
+// If `unset` is true the `NAME_FILE` variables are removed.
+func (env *Environ) LoadFiles(unset bool) error {
+	envMap := map[string]string(*env)
+	values := map[string]string{}
+	for fileName, file := range envMap {
+		if !strings.HasSuffix(fileName, FileSuffix) || fileName == FileSuffix {
+			continue
+		}
+		name := strings.TrimSuffix(fileName, FileSuffix)
+		if _, ok := envMap[name]; ok {
+			return fmt.Errorf("both %s and %s are set", name, fileName)
+		}
+		if data, err := ioutil.ReadFile(file); err == nil {
+			values[name] = strings.TrimRight(string(data), "\r\n")
+		} else {
+			return fmt.Errorf("%s: %s", fileName, err)
+		}
+	}
+	if unset {
+		for name := range values {
+			delete(envMap, name+FileSuffix)
+		}
+	}
+	env.Update(values)
+	return nil
+}
+
 // Values returns the Environ as a array of NAME=VALUE formatted strings.
 func (env *Environ) Values() []string {
 	values := make([]string, 0, len(*env))
@@ -49,6 +88,69 @@ func (env *Environ) Context() map[string]interface{} {
 	return context
 }
 
+// Nested converts the variables whose names begin with `prefix` followed by
+// `__` into a nested map. Names are split on `__` and lowercased so that
+// `APP__DB__HOST=x` becomes `{"app": {"db": {"host": "x"}}}`. It is an error
+// for a name to refer to both a value and a map.
+func (env *Environ) Nested(prefix string) (map[string]interface{}, error) {
+	envMap := map[string]string(*env)
+	names := make([]string, 0, len(envMap))
+	for name := range envMap {
+		if strings.HasPrefix(name, prefix+NestedSeparator) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	nested := map[string]interface{}{}
+	for _, name := range names {
+		keys := strings.Split(strings.ToLower(name), NestedSeparator)
+		node := nested
+		for n, key := range keys {
+			if key == "" {
+				return nil, fmt.Errorf("%s: empty key", name)
+			}
+			if n == len(keys)-1 {
+				if _, ok := node[key]; ok {
+					return nil, fmt.Errorf("%s: conflicts with another variable", name)
+				}
+				node[key] = envMap[name]
+			} else if child, ok := node[key]; !ok {
+				childMap := map[string]interface{}{}
+				node[key] = childMap
+				node = childMap
+			} else if childMap, ok := child.(map[string]interface{}); ok {
+				node = childMap
+			} else {
+				return nil, fmt.Errorf("%s: conflicts with another variable", name)
+			}
+		}
+	}
+	return nested, nil
+}
+
+// Filter returns a copy of the environment containing only the variables for
+// which `keep` returns true.
+func (env *Environ) Filter(keep func(name string) bool) *Environ {
+	filtered := Environ{}
+	for name, value := range *env {
+		if keep(name) {
+			filtered[name] = value
+		}
+	}
+	return &filtered
+}
+
+// MatchEnvName returns true if `name` matches any of the glob patterns.
+func MatchEnvName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // ParseEnvVar parses a single environment variable.
 func ParseEnvVar(envVar string) (string, string) {
 	parts := strings.SplitN(envVar, "=", 2)
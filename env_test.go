@@ -1,6 +1,9 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"testing"
 )
@@ -65,3 +68,114 @@ func TestEnviron(t *testing.T) {
 		t.Errorf("  want: %+v\n", want)
 	}
 }
+
+func TestEnvironLoadFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	secret := path.Join(tmp, "secret")
+	if err := ioutil.WriteFile(secret, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// keep the _FILE variables
+	in := []string{"A=aye", "DB_PASSWORD_FILE=" + secret}
+	want := []string{"A=aye", "DB_PASSWORD=hunter2", "DB_PASSWORD_FILE=" + secret}
+	e := &Environ{}
+	e.Load(in)
+	if err := e.LoadFiles(false); err != nil {
+		t.Error(err)
+	}
+	if have := e.Values(); !envsEqual(have, want) {
+		t.Error("environ values not equal")
+		t.Errorf("  have: %+v\n", have)
+		t.Errorf("  want: %+v\n", want)
+	}
+
+	// unset the _FILE variables
+	want = []string{"A=aye", "DB_PASSWORD=hunter2"}
+	e = &Environ{}
+	e.Load(in)
+	if err := e.LoadFiles(true); err != nil {
+		t.Error(err)
+	}
+	if have := e.Values(); !envsEqual(have, want) {
+		t.Error("environ values not equal")
+		t.Errorf("  have: %+v\n", have)
+		t.Errorf("  want: %+v\n", want)
+	}
+
+	// both set
+	e = &Environ{}
+	e.Load([]string{"DB_PASSWORD=x", "DB_PASSWORD_FILE=" + secret})
+	if err := e.LoadFiles(false); err == nil {
+		t.Error("no error")
+	}
+
+	// missing file
+	e = &Environ{}
+	e.Load([]string{"DB_PASSWORD_FILE=" + path.Join(tmp, "nope")})
+	if err := e.LoadFiles(false); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestEnvironNested(t *testing.T) {
+	e := &Environ{}
+	e.Load([]string{
+		"APP__DB__HOST=x",
+		"APP__DB__PORT=5432",
+		"APP__NAME=app",
+		"APP_OTHER=nope",
+		"OTHER__DB__HOST=nope",
+	})
+	want := map[string]interface{}{
+		"app": map[string]interface{}{
+			"db":   map[string]interface{}{"host": "x", "port": "5432"},
+			"name": "app",
+		},
+	}
+	if have, err := e.Nested("APP"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(have, want) {
+		t.Error("values not equal")
+		t.Errorf("  have: %+v\n", have)
+		t.Errorf("  want: %+v\n", want)
+	}
+
+	// conflicts
+	for _, in := range [][]string{
+		{"APP__DB=x", "APP__DB__HOST=y"},
+		{"APP__DB__HOST=y", "APP__DB=x"},
+		{"APP__DB____HOST=y"},
+	} {
+		e = &Environ{}
+		e.Load(in)
+		if _, err := e.Nested("APP"); err == nil {
+			t.Errorf("no error: %+v", in)
+		}
+	}
+}
+
+func TestEnvironFilter(t *testing.T) {
+	env := &Environ{"PATH": "/bin", "DB_PASSWORD": "secret", "DB_HOST": "db", "HOME": "/root"}
+	filtered := env.Filter(func(name string) bool {
+		return !MatchEnvName([]string{"DB_PASS*"}, name)
+	})
+	want := &Environ{"PATH": "/bin", "DB_HOST": "db", "HOME": "/root"}
+	if !reflect.DeepEqual(filtered, want) {
+		t.Errorf("%+v != %+v", filtered, want)
+	}
+	if len(*env) != 4 {
+		t.Error("environment modified")
+	}
+
+	if !MatchEnvName([]string{"LC_*", "PATH"}, "PATH") || MatchEnvName([]string{"LC_*"}, "LANG") {
+		t.Error("invalid match")
+	}
+}
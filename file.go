@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// WriteFileAtomic writes data to a temporary file in the same directory as
+// `path`, syncs it, and renames it over `path`. Readers will see either the
+// old or the new contents but never a partially written file. The mode and
+// ownership of an existing file are preserved unless overridden by `mode`,
+// `uid`, and `gid` as in SetFileAttrs.
+func WriteFileAtomic(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	dir := filepath.Dir(path)
+	tmp, err := createTemp(dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	cleanup := func(err error) error {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		return cleanup(err)
+	}
+	if info, err := os.Stat(path); err == nil {
+		existingUid, existingGid := -1, -1
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			existingUid, existingGid = int(stat.Uid), int(stat.Gid)
+		}
+		if err := SetFileAttrs(tmp.Name(), info.Mode().Perm(), existingUid, existingGid); err != nil {
+			return cleanup(err)
+		}
+	} else if !os.IsNotExist(err) {
+		return cleanup(err)
+	}
+	if err := SetFileAttrs(tmp.Name(), mode, uid, gid); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// SetFileAttrs sets the mode and ownership of a file. They are only changed if
+// they differ from the file's current attributes. A zero `mode` leaves the
+// mode unchanged and a negative `uid` or `gid` leaves that id unchanged.
+func SetFileAttrs(path string, mode os.FileMode, uid, gid int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if mode != 0 && info.Mode().Perm() != mode.Perm() {
+		if err := os.Chmod(path, mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if uid == int(stat.Uid) {
+			uid = -1
+		}
+		if gid == int(stat.Gid) {
+			gid = -1
+		}
+	}
+	if uid >= 0 || gid >= 0 {
+		return os.Chown(path, uid, gid)
+	}
+	return nil
+}
+
+// createTemp creates a new file in `dir` whose name begins with `prefix`. The
+// file is created with mode 0666 before umask, as os.Create would.
+func createTemp(dir, prefix string) (*os.File, error) {
+	seed := time.Now().UnixNano() + int64(os.Getpid())
+	for i := int64(0); ; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatInt(seed+i, 36))
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// syncDir flushes a directory so a rename within it is durable.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	dst := path.Join(tmp, "dst")
+
+	// new file
+	if err := WriteFileAtomic(dst, []byte("one"), 0, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := ioutil.ReadFile(dst); err != nil {
+		t.Error(err)
+	} else if string(have) != "one" {
+		t.Errorf("'%s' != 'one'", have)
+	}
+
+	// existing file mode is preserved
+	if err := os.Chmod(dst, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(dst, []byte("two"), 0, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := ioutil.ReadFile(dst); err != nil {
+		t.Error(err)
+	} else if string(have) != "two" {
+		t.Errorf("'%s' != 'two'", have)
+	}
+	if info, err := os.Stat(dst); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0640 {
+		t.Errorf("%o != 0640", info.Mode().Perm())
+	}
+
+	// no temp files are left behind
+	if files, err := ioutil.ReadDir(tmp); err != nil {
+		t.Error(err)
+	} else if len(files) != 1 {
+		t.Errorf("%d files in %s", len(files), tmp)
+	}
+}
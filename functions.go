@@ -119,3 +119,15 @@ func JSON(item interface{}) (interface{}, error) {
 	}
 	return data, err
 }
+
+// Indent prefixes each non-empty line in `s` with the given number of spaces.
+func Indent(spaces int, s string) string {
+	prefix := strings.Repeat(" ", spaces)
+	lines := strings.Split(s, "\n")
+	for n, line := range lines {
+		if line != "" {
+			lines[n] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
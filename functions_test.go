@@ -220,3 +220,23 @@ func TestJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestIndent(t *testing.T) {
+	tests := []struct {
+		spaces int
+		in     string
+		want   string
+	}{
+		{2, "", ""},
+		{2, "a", "  a"},
+		{4, "a\nb\n", "    a\n    b\n"},
+		{1, "a\n\nb", " a\n\n b"},
+	}
+
+	for _, test := range tests {
+		have := Indent(test.spaces, test.in)
+		if have != test.want {
+			t.Errorf("%q != %q", have, test.want)
+		}
+	}
+}
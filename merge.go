@@ -3,40 +3,310 @@ package main
 import (
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 var (
 	MaxDepth = 32
 )
 
+// DeleteMarker is a value which removes its key from the destination map when
+// merged.
+const DeleteMarker = "~delete"
+
+// MergeStrategyNames are the strategies which may be given for a key path.
+var MergeStrategyNames = []string{"replace", "append", "prepend", "unique-append", "merge-by-key", "keep-first"}
+
+// MergeStrategy describes how the value at a key path is merged. Path is
+// parsed by ParsePath and may contain `*` to match any key. Key is the field
+// used to match maps in lists merged with the `merge-by-key` strategy. It
+// defaults to `name`.
+type MergeStrategy struct {
+	Path     string
+	Strategy string
+	Key      string
+	elements []interface{}
+}
+
+// UnmarshalYAML accepts either a full strategy or the strategy name.
+func (s *MergeStrategy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		s.Strategy = name
+		return nil
+	}
+	type plain MergeStrategy
+	return unmarshal((*plain)(s))
+}
+
+// Validate parses the path and checks the strategy name.
+func (s *MergeStrategy) Validate() error {
+	elements, err := ParsePath(s.Path)
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		if _, ok := element.(string); !ok {
+			return fmt.Errorf("merge path '%s' may not contain an index", s.Path)
+		}
+	}
+	for _, name := range MergeStrategyNames {
+		if s.Strategy == name {
+			s.elements = elements
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown merge strategy %s for %s", s.Strategy, s.Path)
+}
+
+// match returns true if the strategy applies to the value at `path`.
+func (s *MergeStrategy) match(path []string) bool {
+	if len(path) != len(s.elements) {
+		return false
+	}
+	for n, element := range s.elements {
+		if element != "*" && element != path[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// moreSpecific returns true if the strategy has a key where `other` has `*` at
+// the first element where they differ. Both must match the same path.
+func (s *MergeStrategy) moreSpecific(other *MergeStrategy) bool {
+	for n, element := range s.elements {
+		if wild, otherWild := element == "*", other.elements[n] == "*"; wild != otherWild {
+			return otherWild
+		}
+	}
+	return false
+}
+
+// MergeStrategies is a list of merge strategies. In YAML it is a map of key
+// path to strategy.
+type MergeStrategies []MergeStrategy
+
+// UnmarshalYAML reads the map of key path to strategy. Strategies are sorted
+// by path and validated.
+func (s *MergeStrategies) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	entries := map[string]MergeStrategy{}
+	strategies := MergeStrategies{}
+	err := unmarshalPathMap(unmarshal, &entries, func(path string) error {
+		entry := entries[path]
+		entry.Path = path
+		if err := entry.Validate(); err != nil {
+			return err
+		}
+		strategies = append(strategies, entry)
+		return nil
+	})
+	*s = strategies
+	return err
+}
+
 // Merge recursively merges the src and dst maps. Key conflicts are resolved by
 // preferring src, or recursively descending, if both src and dst are maps.
-// Arrays are merged by appending src to dst.
-func Merge(dst, src map[string]interface{}, arrayAppend bool) map[string]interface{} {
-	return merge(dst, src, arrayAppend, 0)
+// Arrays are merged by appending src to dst if arrayAppend is true. An error is
+// returned if the maps are nested deeper than MaxDepth or if a map contains
+// itself.
+func Merge(dst, src map[string]interface{}, arrayAppend bool) (map[string]interface{}, error) {
+	merger := &Merger{ArrayAppend: arrayAppend}
+	return merger.Merge(dst, src)
 }
 
-func merge(dst, src map[string]interface{}, arrayAppend bool, depth int) map[string]interface{} {
-	if depth > MaxDepth {
-		panic("too deep!")
+// Merger merges maps as Merge does with the strategy for each key path given
+// by the most specific matching entry in Strategies. If Delete is true a value
+// of DeleteMarker in src removes the key from dst. If Sources is set each value
+// written to dst is recorded in it as set by Source. The strategies are:
+//
+//	replace        replace dst with src without merging maps
+//	append         append src to dst
+//	prepend        prepend src to dst
+//	unique-append  append the items in src which are not in dst
+//	merge-by-key   merge lists of maps which have the same value for Key
+//	keep-first     keep dst if it is set
+type Merger struct {
+	ArrayAppend bool
+	Strategies  []MergeStrategy
+	Delete      bool
+	Sources     ContextSources
+	Source      string
+}
+
+// Merge merges src into dst and returns dst.
+func (m *Merger) Merge(dst, src map[string]interface{}) (map[string]interface{}, error) {
+	return m.merge(dst, src, nil, []uintptr{mapPointer(dst), mapPointer(src)})
+}
+
+func (m *Merger) merge(dst, src map[string]interface{}, path []string, ancestors []uintptr) (map[string]interface{}, error) {
+	if len(path) > MaxDepth {
+		return nil, fmt.Errorf("maximum merge depth of %d exceeded at %s", MaxDepth, strings.Join(path, "."))
 	}
 	for key, srcVal := range src {
-		if dstVal, ok := dst[key]; ok {
-			srcMap, srcMapOk := mapify(srcVal)
+		keyPath := append(path[:len(path):len(path)], key)
+		if m.Delete && srcVal == DeleteMarker {
+			delete(dst, key)
+			if m.Sources != nil {
+				m.Sources.Delete(keyPath)
+			}
+			continue
+		}
+
+		strategy := m.strategy(keyPath)
+		dstVal, exists := dst[key]
+		if exists && strategy.Strategy == "keep-first" {
+			continue
+		}
+
+		merged := false
+		if srcMap, ok := mapify(srcVal); ok && strategy.Strategy != "replace" {
+			merged = true
 			dstMap, dstMapOk := mapify(dstVal)
-			if srcMapOk && dstMapOk {
-				srcVal = merge(dstMap, srcMap, arrayAppend, depth+1)
-			} else if arrayAppend {
-				srcArr, srcArrOk := arrayify(srcVal)
-				dstArr, dstArrOk := arrayify(dstVal)
-				if srcArrOk && dstArrOk {
+			keyAncestors, err := descend(ancestors, keyPath, srcVal, dstVal, dstMapOk)
+			if err != nil {
+				return nil, err
+			}
+			if srcVal, err = m.merge(dstMap, srcMap, keyPath, keyAncestors); err != nil {
+				return nil, err
+			}
+		} else if exists {
+			srcArr, srcArrOk := arrayify(srcVal)
+			dstArr, dstArrOk := arrayify(dstVal)
+			if srcArrOk && dstArrOk {
+				switch strategy.Strategy {
+				case "":
+					if m.ArrayAppend {
+						srcVal = append(dstArr, srcArr...)
+					}
+				case "append":
 					srcVal = append(dstArr, srcArr...)
+				case "prepend":
+					srcVal = append(srcArr, dstArr...)
+				case "unique-append":
+					srcVal = uniqueAppend(dstArr, srcArr)
+				case "merge-by-key":
+					var err error
+					if srcVal, err = m.mergeByKey(dstArr, srcArr, strategy.Key, keyPath, ancestors); err != nil {
+						return nil, err
+					}
 				}
 			}
 		}
 		dst[key] = srcVal
+		if m.Sources != nil && !merged {
+			m.Sources.Set(keyPath, m.Source, srcVal)
+		}
+	}
+	return dst, nil
+}
+
+// mergeByKey merges each map in src with the map in dst which has the same
+// value for `key`. Those without a match are appended.
+func (m *Merger) mergeByKey(dst, src []interface{}, key string, path []string, ancestors []uintptr) ([]interface{}, error) {
+	if key == "" {
+		key = "name"
+	}
+	// sources are recorded for the list rather than the items in it
+	items := *m
+	items.Sources = nil
+	merged := append([]interface{}{}, dst...)
+	for _, srcItem := range src {
+		srcMap, ok := mapify(srcItem)
+		if !ok {
+			merged = append(merged, srcItem)
+			continue
+		}
+
+		match := -1
+		if id, ok := srcMap[key]; ok {
+			for n, dstItem := range merged {
+				if dstMap, ok := mapify(dstItem); ok && reflect.DeepEqual(dstMap[key], id) {
+					match = n
+					break
+				}
+			}
+		}
+		var dstItem interface{}
+		if match >= 0 {
+			dstItem = merged[match]
+		}
+		dstMap, dstMapOk := mapify(dstItem)
+		itemAncestors, err := descend(ancestors, path, srcItem, dstItem, dstMapOk)
+		if err != nil {
+			return nil, err
+		}
+		item, err := items.merge(dstMap, srcMap, path, itemAncestors)
+		if err != nil {
+			return nil, err
+		}
+		if match >= 0 {
+			merged[match] = item
+		} else {
+			merged = append(merged, item)
+		}
 	}
-	return dst
+	return merged, nil
+}
+
+// strategy returns the most specific strategy which matches `path` or an empty
+// strategy if none do. At the first element where two matching strategies
+// differ, the one with a key is more specific than the one with `*`. The first
+// of equally specific strategies wins.
+func (m *Merger) strategy(path []string) *MergeStrategy {
+	var best *MergeStrategy
+	for n := range m.Strategies {
+		if strategy := &m.Strategies[n]; strategy.match(path) && (best == nil || strategy.moreSpecific(best)) {
+			best = strategy
+		}
+	}
+	if best == nil {
+		return &MergeStrategy{}
+	}
+	return best
+}
+
+// descend adds the maps being merged at `path` to the ancestors of the maps
+// below it. An error is returned if either is already an ancestor.
+func descend(ancestors []uintptr, path []string, srcVal, dstVal interface{}, dstMapOk bool) ([]uintptr, error) {
+	values := []interface{}{srcVal}
+	if dstMapOk {
+		values = append(values, dstVal)
+	}
+	descendants := ancestors[:len(ancestors):len(ancestors)]
+	for _, value := range values {
+		ptr := mapPointer(value)
+		for _, ancestor := range ancestors {
+			if ptr == ancestor {
+				return nil, fmt.Errorf("cycle detected at %s", strings.Join(path, "."))
+			}
+		}
+		descendants = append(descendants, ptr)
+	}
+	return descendants, nil
+}
+
+// uniqueAppend appends the items in src which are not in dst.
+func uniqueAppend(dst, src []interface{}) []interface{} {
+	merged := append([]interface{}{}, dst...)
+	for _, srcItem := range src {
+		found := false
+		for _, item := range merged {
+			if reflect.DeepEqual(item, srcItem) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, srcItem)
+		}
+	}
+	return merged
+}
+
+// mapPointer returns the address of the map `m` refers to.
+func mapPointer(m interface{}) uintptr {
+	return reflect.ValueOf(m).Pointer()
 }
 
 func mapify(i interface{}) (map[string]interface{}, bool) {
@@ -47,7 +317,7 @@ func mapify(i interface{}) (map[string]interface{}, bool) {
 	if value.Kind() == reflect.Map {
 		m = make(map[string]interface{}, value.Len())
 		for _, k := range value.MapKeys() {
-			m[fmt.Sprintf("%s", k.Interface())] = value.MapIndex(k).Interface()
+			m[fmt.Sprint(k.Interface())] = value.MapIndex(k).Interface()
 		}
 		ok = true
 	}
@@ -3,6 +3,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -86,11 +89,161 @@ func TestMerge(t *testing.T) {
 			continue
 		}
 
-		got := Merge(dst, src, tuple.arrayAppend)
+		got, err := Merge(dst, src, tuple.arrayAppend)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
 		assert(t, expected, got)
 	}
 }
 
+func TestMergeErrors(t *testing.T) {
+	// too deep
+	nest := func(depth int) map[string]interface{} {
+		m := map[string]interface{}{}
+		for n := 0; n < depth; n++ {
+			m = map[string]interface{}{"a": m}
+		}
+		return m
+	}
+	if _, err := Merge(nest(MaxDepth), nest(MaxDepth), false); err != nil {
+		t.Error(err)
+	}
+	want := fmt.Sprintf("maximum merge depth of %d exceeded at %s", MaxDepth, strings.Repeat("a.", MaxDepth)+"a")
+	if _, err := Merge(nest(MaxDepth+2), nest(MaxDepth+2), false); err == nil {
+		t.Error("no error")
+	} else if err.Error() != want {
+		t.Errorf("%q != %q", err.Error(), want)
+	}
+
+	// cycle
+	src := map[string]interface{}{}
+	src["b"] = map[string]interface{}{"self": src}
+	dst := map[string]interface{}{"b": map[string]interface{}{"self": map[string]interface{}{}}}
+	want = "cycle detected at b.self"
+	if _, err := Merge(dst, src, false); err == nil {
+		t.Error("no error")
+	} else if err.Error() != want {
+		t.Errorf("%q != %q", err.Error(), want)
+	}
+
+	// shared values are not cycles
+	shared := map[string]interface{}{"x": 1}
+	src = map[string]interface{}{"a": shared, "b": shared}
+	dst = map[string]interface{}{"a": map[string]interface{}{}, "b": map[string]interface{}{}}
+	if _, err := Merge(dst, src, false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMerger(t *testing.T) {
+	strategies := MergeStrategies{
+		{Path: "replaced", Strategy: "replace"},
+		{Path: "appended", Strategy: "append"},
+		{Path: "prepended", Strategy: "prepend"},
+		{Path: "unique", Strategy: "unique-append"},
+		{Path: "users", Strategy: "merge-by-key"},
+		{Path: "hosts", Strategy: "merge-by-key", Key: "addr"},
+		{Path: "apps.*.first", Strategy: "keep-first"},
+		{Path: "apps.*.args", Strategy: "replace"},
+		{Path: "apps.web.args", Strategy: "append"},
+	}
+	for n := range strategies {
+		if err := strategies[n].Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	merger := &Merger{Strategies: strategies, Delete: true}
+
+	dst := `{
+		"replaced": {"a": 1},
+		"appended": [1, 2],
+		"prepended": [1, 2],
+		"unique": [1, 2],
+		"users": [{"name": "a", "uid": 1, "groups": ["x"]}, {"name": "b", "uid": 2}],
+		"hosts": [{"addr": "h1", "port": 80}],
+		"apps": {"web": {"first": "dst", "other": "dst", "args": ["a"]}, "api": {"args": ["a"]}},
+		"arrays": [1],
+		"deleted": {"a": 1},
+		"nested": {"keep": 1, "drop": 2}
+	}`
+	src := `{
+		"replaced": {"b": 2},
+		"appended": [3],
+		"prepended": [3],
+		"unique": [2, 3],
+		"users": [{"name": "b", "uid": 3}, {"name": "c", "uid": 4}, {"uid": 5}],
+		"hosts": [{"addr": "h1", "port": 8080}],
+		"apps": {"web": {"first": "src", "other": "src", "args": ["b"]}, "api": {"first": "src", "args": ["b"]}},
+		"arrays": [2],
+		"deleted": "~delete",
+		"nested": {"drop": "~delete", "add": {"a": 1, "b": "~delete"}},
+		"missing": "~delete"
+	}`
+	expected := `{
+		"replaced": {"b": 2},
+		"appended": [1, 2, 3],
+		"prepended": [3, 1, 2],
+		"unique": [1, 2, 3],
+		"users": [{"name": "a", "uid": 1, "groups": ["x"]}, {"name": "b", "uid": 3}, {"name": "c", "uid": 4}, {"uid": 5}],
+		"hosts": [{"addr": "h1", "port": 8080}],
+		"apps": {"web": {"first": "dst", "other": "src", "args": ["a", "b"]}, "api": {"args": ["b"], "first": "src"}},
+		"arrays": [2],
+		"nested": {"keep": 1, "add": {"a": 1}}
+	}`
+
+	var dstMap, srcMap, expectedMap map[string]interface{}
+	for _, item := range []struct {
+		data string
+		m    *map[string]interface{}
+	}{{dst, &dstMap}, {src, &srcMap}, {expected, &expectedMap}} {
+		if err := json.Unmarshal([]byte(item.data), item.m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := merger.Merge(dstMap, srcMap); err != nil {
+		t.Error(err)
+	} else {
+		assert(t, expectedMap, got)
+	}
+}
+
+func TestMergerSources(t *testing.T) {
+	strategies := MergeStrategies{{Path: "first", Strategy: "keep-first"}}
+	if err := strategies[0].Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sources := ContextSources{}
+	merger := &Merger{Strategies: strategies, Delete: true, Sources: sources}
+	dst := map[string]interface{}{}
+
+	merger.Source = "a"
+	if _, err := merger.Merge(dst, map[string]interface{}{
+		"first":   "a",
+		"deleted": "a",
+		"nested":  map[string]interface{}{"x": "a", "y": "a"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	merger.Source = "b"
+	if _, err := merger.Merge(dst, map[string]interface{}{
+		"first":   "b",
+		"deleted": DeleteMarker,
+		"nested":  map[string]interface{}{"y": "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ContextSources{
+		"first":  "a",
+		"nested": ContextSources{"x": "a", "y": "b"},
+	}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("%+v != %+v", sources, want)
+	}
+}
+
 func assert(t *testing.T, expected, got map[string]interface{}) {
 	expectedBuf, err := json.Marshal(expected)
 	if err != nil {
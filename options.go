@@ -2,19 +2,35 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
 	"strings"
 )
 
-// MapVar is a string map which satisfies the Value interface.
+// MapVar is a string map which satisfies the Value interface. Values are
+// formatted as `name=value` or `name:type=value` where type is one of
+// ValueTypes. The name is a key path as parsed by ParsePath so that values may
+// be set in nested maps and arrays.
 type MapVar struct {
-	Context map[string]interface{}
+	Context     map[string]interface{}
+	assignments []mapAssignment
+}
+
+// mapAssignment is a value set on a MapVar.
+type mapAssignment struct {
+	raw     string
+	path    []interface{}
+	value   interface{}
+	untyped bool
 }
 
 func (v *MapVar) String() string {
-	parts := make([]string, 0, len(v.Context))
-	for name, value := range v.Context {
-		parts = append(parts, name+"="+fmt.Sprint(value))
+	parts := make([]string, len(v.assignments))
+	for n, assignment := range v.assignments {
+		parts[n] = assignment.raw
 	}
 	return strings.Join(parts, ",")
 }
@@ -23,16 +39,80 @@ func (v *MapVar) Set(value string) error {
 	if v.Context == nil {
 		v.Context = map[string]interface{}{}
 	}
-	parts := strings.SplitN(value, "=", 2)
-	if len(parts) == 1 {
-		v.Context[parts[0]] = ""
-	} else {
-		v.Context[parts[0]] = parts[1]
+	assignment := mapAssignment{raw: value}
+	name, value := splitAssignment(value)
+
+	typ := ""
+	if n := strings.LastIndex(name, ":"); n >= 0 && IsValueType(name[n+1:]) {
+		name, typ = name[:n], name[n+1:]
+	}
+	path, err := ParsePath(name)
+	if err != nil {
+		return err
 	}
+	assignment.path = path
+	if typ == "" {
+		assignment.value = value
+		assignment.untyped = true
+	} else if assignment.value, err = ConvertValue(typ, value); err != nil {
+		return err
+	}
+
+	if err := SetPath(v.Context, path, assignment.value); err != nil {
+		return err
+	}
+	v.assignments = append(v.assignments, assignment)
 	return nil
 }
 
-// JsonVar is a JSON object which satisfies the Value interface.
+// Inferred returns a copy of the context with InferValue applied to the values
+// which were not given an explicit type.
+func (v *MapVar) Inferred() map[string]interface{} {
+	inferred := map[string]interface{}{}
+	v.Apply(inferred, true)
+	return inferred
+}
+
+// Apply sets each value at its path in `context` in the order the values were
+// given so that an index sets a single element of an existing array. A value
+// of DeleteMarker removes the key at its path. InferValue is applied to
+// untyped values if `infer` is true.
+func (v *MapVar) Apply(context map[string]interface{}, infer bool) error {
+	for _, assignment := range v.assignments {
+		value := assignment.value
+		if assignment.untyped && infer {
+			value = InferValue(value.(string))
+		}
+		var err error
+		if value == DeleteMarker {
+			err = DeletePath(context, assignment.path)
+		} else {
+			err = SetPath(context, assignment.path, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitAssignment splits `name=value` at the first `=` which is not escaped
+// by a backslash. The escape is left in the name to be handled by ParsePath.
+func splitAssignment(assignment string) (string, string) {
+	for i := 0; i < len(assignment); i++ {
+		switch assignment[i] {
+		case '\\':
+			i++
+		case '=':
+			return assignment[:i], assignment[i+1:]
+		}
+	}
+	return assignment, ""
+}
+
+// JsonVar is a JSON object which satisfies the Value interface. A value
+// beginning with `@` is the path to a file containing the object and a value
+// of `-` reads it from stdin.
 type JsonVar struct {
 	Context map[string]interface{}
 }
@@ -42,13 +122,101 @@ func (j *JsonVar) String() string {
 }
 
 func (v *JsonVar) Set(value string) error {
-	if v.Context == nil {
-		v.Context = map[string]interface{}{}
+	data, err := readVarData(value)
+	if err != nil {
+		return err
 	}
 	m := map[string]interface{}{}
-	err := json.Unmarshal([]byte(value), &m)
-	if err == nil {
-		v.Context = Merge(v.Context, m, true)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
 	}
+	return v.merge(m)
+}
+
+func (v *JsonVar) merge(m map[string]interface{}) error {
+	if v.Context == nil {
+		v.Context = map[string]interface{}{}
+	}
+	_, err := Merge(v.Context, m, true)
 	return err
 }
+
+// YamlVar is a YAML object which satisfies the Value interface. Values are
+// read as they are by JsonVar and merged into its context so that the order of
+// JSON and YAML values is kept.
+type YamlVar struct {
+	*JsonVar
+}
+
+func (v YamlVar) Set(value string) error {
+	data, err := readVarData(value)
+	if err != nil {
+		return err
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		return nil
+	}
+	m, ok := Normalize(raw).(map[string]interface{})
+	if !ok {
+		return errors.New("not a map")
+	}
+	return v.merge(m)
+}
+
+// readVarData returns the data given by an option value. The value is read
+// from a file if it begins with `@` or from stdin if it is `-`.
+func readVarData(value string) ([]byte, error) {
+	switch {
+	case value == "-":
+		return ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(value, "@"):
+		return ioutil.ReadFile(value[1:])
+	default:
+		return []byte(value), nil
+	}
+}
+
+// ContextFileVar is a list of context files which satisfies the Value
+// interface. Each value is formatted as `path` or `key=path`.
+type ContextFileVar struct {
+	Files []ContextFile
+}
+
+func (v *ContextFileVar) String() string {
+	parts := make([]string, len(v.Files))
+	for n, file := range v.Files {
+		if file.Key == "" {
+			parts[n] = file.Path
+		} else {
+			parts[n] = file.Key + "=" + file.Path
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v *ContextFileVar) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) == 1 {
+		v.Files = append(v.Files, ContextFile{Path: parts[0]})
+	} else {
+		v.Files = append(v.Files, ContextFile{Path: parts[1], Key: parts[0]})
+	}
+	return nil
+}
+
+// StringsVar is a list of strings which satisfies the Value interface. Each
+// use of the option appends a value.
+type StringsVar []string
+
+func (v *StringsVar) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *StringsVar) Set(value string) error {
+	*v = append(*v, value)
+	return nil
+}
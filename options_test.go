@@ -1,6 +1,9 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"testing"
 )
@@ -47,6 +50,88 @@ func TestMapVar(t *testing.T) {
 	}
 }
 
+func TestMapVarTyped(t *testing.T) {
+	s := &MapVar{}
+	for _, value := range []string{"a:int=5", "b:json=[1]", "c=5", "d:string=5", "e=false", "url:x=y"} {
+		if err := s.Set(value); err != nil {
+			t.Error(err)
+		}
+	}
+	want := map[string]interface{}{
+		"a":     int64(5),
+		"b":     []interface{}{1.0},
+		"c":     "5",
+		"d":     "5",
+		"e":     "false",
+		"url:x": "y",
+	}
+	if !reflect.DeepEqual(s.Context, want) {
+		t.Error("value invalid")
+		t.Errorf("  have: %+v", s.Context)
+		t.Errorf("  want: %+v", want)
+	}
+
+	want = map[string]interface{}{
+		"a":     int64(5),
+		"b":     []interface{}{1.0},
+		"c":     int64(5),
+		"d":     "5",
+		"e":     false,
+		"url:x": "y",
+	}
+	if have := s.Inferred(); !reflect.DeepEqual(have, want) {
+		t.Error("inferred value invalid")
+		t.Errorf("  have: %+v", have)
+		t.Errorf("  want: %+v", want)
+	}
+
+	if err := s.Set("a:int=five"); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestMapVarPaths(t *testing.T) {
+	s := &MapVar{}
+	for _, value := range []string{"db.host=foo", "db.port:int=5432", `a\.b=c`, "servers[1]=b", "servers[0]=a", `x\=y=z`} {
+		if err := s.Set(value); err != nil {
+			t.Error(err)
+		}
+	}
+	want := map[string]interface{}{
+		"db":      map[string]interface{}{"host": "foo", "port": int64(5432)},
+		"a.b":     "c",
+		"servers": []interface{}{"a", "b"},
+		"x=y":     "z",
+	}
+	if !reflect.DeepEqual(s.Context, want) {
+		t.Error("value invalid")
+		t.Errorf("  have: %+v", s.Context)
+		t.Errorf("  want: %+v", want)
+	}
+	if s.String() != `db.host=foo,db.port:int=5432,a\.b=c,servers[1]=b,servers[0]=a,x\=y=z` {
+		t.Error("String() invalid")
+	}
+
+	s = &MapVar{}
+	for _, value := range []string{"db.port=5432", "db.debug:string=true"} {
+		if err := s.Set(value); err != nil {
+			t.Error(err)
+		}
+	}
+	want = map[string]interface{}{
+		"db": map[string]interface{}{"port": int64(5432), "debug": "true"},
+	}
+	if have := s.Inferred(); !reflect.DeepEqual(have, want) {
+		t.Error("inferred value invalid")
+		t.Errorf("  have: %+v", have)
+		t.Errorf("  want: %+v", want)
+	}
+
+	if err := s.Set("db..host=foo"); err == nil {
+		t.Error("no error")
+	}
+}
+
 func TestJsonVarOneSet(t *testing.T) {
 	// empty
 	j := &JsonVar{}
@@ -119,3 +204,85 @@ func TestJsonVarMultiSetArray(t *testing.T) {
 		t.Errorf("  want: %+v", want)
 	}
 }
+
+func TestJsonVarFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman-options-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	file := path.Join(tmp, "values.json")
+	if err := ioutil.WriteFile(file, []byte(`{"items": ["a"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j := &JsonVar{}
+	if err := j.Set("@" + file); err != nil {
+		t.Error(err)
+	}
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	if os.Stdin, err = os.Open(file); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Stdin.Close()
+	if err := j.Set("-"); err != nil {
+		t.Error(err)
+	}
+
+	want := map[string]interface{}{"items": []interface{}{"a", "a"}}
+	if !reflect.DeepEqual(j.Context, want) {
+		t.Error("value invalid")
+		t.Errorf("  have: %+v", j.Context)
+		t.Errorf("  want: %+v", want)
+	}
+
+	if err := j.Set("@" + path.Join(tmp, "missing.json")); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestYamlVar(t *testing.T) {
+	j := &JsonVar{}
+	y := YamlVar{j}
+	if err := j.Set(`{"items": ["a"], "name": "json"}`); err != nil {
+		t.Error(err)
+	}
+	if err := y.Set("items: [b]\nnested: {a: 1}"); err != nil {
+		t.Error(err)
+	}
+	want := map[string]interface{}{
+		"items":  []interface{}{"a", "b"},
+		"name":   "json",
+		"nested": map[string]interface{}{"a": 1},
+	}
+	if !reflect.DeepEqual(j.Context, want) {
+		t.Error("value invalid")
+		t.Errorf("  have: %+v", j.Context)
+		t.Errorf("  want: %+v", want)
+	}
+
+	if err := y.Set("[a, b]"); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestContextFileVar(t *testing.T) {
+	v := &ContextFileVar{}
+	if err := v.Set("a.json"); err != nil {
+		t.Error(err)
+	}
+	if err := v.Set("app=b.yml"); err != nil {
+		t.Error(err)
+	}
+	want := []ContextFile{{Path: "a.json"}, {Path: "b.yml", Key: "app"}}
+	if !reflect.DeepEqual(v.Files, want) {
+		t.Error("value invalid")
+		t.Errorf("  have: %+v", v.Files)
+		t.Errorf("  want: %+v", want)
+	}
+	if v.String() != "a.json,app=b.yml" {
+		t.Error("String() invalid")
+	}
+}
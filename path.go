@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	MaxPathIndex = 1024
+)
+
+// ParsePath parses a key path such as `servers[0].host` into its elements.
+// Map keys are strings and array indexes are ints. Keys are separated by `.`
+// and indexes are enclosed in brackets. A backslash escapes the character
+// which follows it so that `a\.b` is the single key `a.b`. Indexes may not be
+// greater than MaxPathIndex.
+func ParsePath(path string) ([]interface{}, error) {
+	wrapError := func(err error) error {
+		return fmt.Errorf("invalid path '%s': %s", path, err)
+	}
+
+	elements := []interface{}{}
+	key := &strings.Builder{}
+	pending := true
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 == len(path) {
+				return nil, wrapError(errors.New("trailing backslash"))
+			}
+			i++
+			key.WriteByte(path[i])
+		case '.':
+			if pending && key.Len() == 0 {
+				return nil, wrapError(errors.New("empty key"))
+			}
+			if pending {
+				elements = append(elements, key.String())
+				key.Reset()
+			}
+			pending = true
+		case '[':
+			if pending && key.Len() == 0 && len(elements) == 0 {
+				return nil, wrapError(errors.New("path must begin with a key"))
+			}
+			if pending && key.Len() > 0 {
+				elements = append(elements, key.String())
+				key.Reset()
+			}
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, wrapError(errors.New("unterminated index"))
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil || index < 0 {
+				return nil, wrapError(fmt.Errorf("invalid index '%s'", path[i+1:i+end]))
+			}
+			if index > MaxPathIndex {
+				return nil, wrapError(fmt.Errorf("index %d is greater than %d", index, MaxPathIndex))
+			}
+			elements = append(elements, index)
+			pending = false
+			i += end
+		default:
+			if !pending {
+				return nil, wrapError(fmt.Errorf("unexpected '%c' after index", c))
+			}
+			key.WriteByte(c)
+		}
+	}
+	if pending {
+		if key.Len() == 0 {
+			return nil, wrapError(errors.New("empty key"))
+		}
+		elements = append(elements, key.String())
+	}
+	return elements, nil
+}
+
+// SetPath sets the value at `path` in `root`. Maps and arrays along the path
+// are created as needed and arrays are extended with nil values to reach an
+// index. A value along the path which is not a map or array as required is
+// replaced. Existing maps and arrays along the path are copied rather than
+// modified.
+func SetPath(root map[string]interface{}, path []interface{}, value interface{}) error {
+	if len(path) == 0 {
+		return errors.New("empty path")
+	}
+	key, ok := path[0].(string)
+	if !ok {
+		return errors.New("path must begin with a key")
+	}
+	root[key] = setPath(root[key], path[1:], value)
+	return nil
+}
+
+func setPath(node interface{}, path []interface{}, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	switch key := path[0].(type) {
+	case int:
+		array, _ := arrayify(node)
+		for len(array) <= key {
+			array = append(array, nil)
+		}
+		array[key] = setPath(array[key], path[1:], value)
+		return array
+	default:
+		m, _ := mapify(node)
+		m[key.(string)] = setPath(m[key.(string)], path[1:], value)
+		return m
+	}
+}
+
+// GetPath returns the value at `path` in `root` and whether it was found.
+func GetPath(root map[string]interface{}, path []interface{}) (interface{}, bool) {
+	var node interface{} = root
+	for _, element := range path {
+		switch key := element.(type) {
+		case int:
+			array, ok := arrayify(node)
+			if !ok || key >= len(array) {
+				return nil, false
+			}
+			node = array[key]
+		case string:
+			m, ok := mapify(node)
+			if !ok {
+				return nil, false
+			}
+			if node, ok = m[key]; !ok {
+				return nil, false
+			}
+		}
+	}
+	return node, true
+}
+
+// DeletePath removes the key at the end of `path` from `root`. Nothing is
+// removed if the path is not found or does not end in a key. Maps along the
+// path are copied rather than modified.
+func DeletePath(root map[string]interface{}, path []interface{}) error {
+	if len(path) == 0 {
+		return errors.New("empty path")
+	}
+	key, ok := path[len(path)-1].(string)
+	if !ok {
+		return nil
+	}
+	if len(path) == 1 {
+		delete(root, key)
+		return nil
+	}
+	parent, ok := GetPath(root, path[:len(path)-1])
+	if !ok {
+		return nil
+	}
+	if m, ok := mapify(parent); ok {
+		if _, ok := m[key]; ok {
+			delete(m, key)
+			return SetPath(root, path[:len(path)-1], m)
+		}
+	}
+	return nil
+}
+
+// unmarshalPathMap unmarshals a YAML map keyed by path into `entries`, which
+// must point to a map with string keys, and calls `add` with each path in
+// sorted order.
+func unmarshalPathMap(unmarshal func(interface{}) error, entries interface{}, add func(string) error) error {
+	if err := unmarshal(entries); err != nil {
+		return err
+	}
+	keys := reflect.ValueOf(entries).Elem().MapKeys()
+	paths := make([]string, len(keys))
+	for n, key := range keys {
+		paths[n] = key.String()
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := add(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
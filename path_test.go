@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []interface{}
+	}{
+		{"a", []interface{}{"a"}},
+		{"db.host", []interface{}{"db", "host"}},
+		{`a\.b.c`, []interface{}{"a.b", "c"}},
+		{`a\\.b`, []interface{}{`a\`, "b"}},
+		{"servers[0]", []interface{}{"servers", 0}},
+		{"servers[1].host", []interface{}{"servers", 1, "host"}},
+		{"grid[2][3]", []interface{}{"grid", 2, 3}},
+		{`a\[0]`, []interface{}{"a[0]"}},
+	}
+	for _, test := range tests {
+		have, err := ParsePath(test.path)
+		if err != nil {
+			t.Errorf("%s: %s", test.path, err)
+		} else if !reflect.DeepEqual(have, test.want) {
+			t.Errorf("%s: path invalid", test.path)
+			t.Errorf("  have: %#v", have)
+			t.Errorf("  want: %#v", test.want)
+		}
+	}
+
+	for _, path := range []string{"", "a.", ".a", "a..b", "[0]", "a[", "a[x]", "a[-1]", "a[0]b", `a\`, "a[100000000000]"} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("%s: no error", path)
+		}
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	nested := map[string]interface{}{"port": 5432}
+	servers := []interface{}{"a"}
+	root := map[string]interface{}{
+		"db":      nested,
+		"servers": servers,
+		"name":    "app",
+	}
+	set := func(path string, value interface{}) {
+		if elements, err := ParsePath(path); err != nil {
+			t.Fatal(err)
+		} else if err := SetPath(root, elements, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set("db.host", "localhost")
+	set("servers[2]", "c")
+	set("name.first", "app")
+	set("grid[1][0]", 1)
+
+	want := map[string]interface{}{
+		"db":      map[string]interface{}{"port": 5432, "host": "localhost"},
+		"servers": []interface{}{"a", nil, "c"},
+		"name":    map[string]interface{}{"first": "app"},
+		"grid":    []interface{}{nil, []interface{}{1}},
+	}
+	if !reflect.DeepEqual(root, want) {
+		t.Error("value invalid")
+		t.Errorf("  have: %+v", root)
+		t.Errorf("  want: %+v", want)
+	}
+	if len(nested) != 1 || len(servers) != 1 {
+		t.Error("existing values modified")
+	}
+
+	if err := SetPath(root, []interface{}{0}, "x"); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestDeletePath(t *testing.T) {
+	nested := map[string]interface{}{"host": "db", "port": 5432}
+	root := map[string]interface{}{"db": nested, "name": "app"}
+	for _, path := range [][]interface{}{{"db", "port"}, {"name"}, {"missing", "key"}} {
+		if err := DeletePath(root, path); err != nil {
+			t.Error(err)
+		}
+	}
+	want := map[string]interface{}{"db": map[string]interface{}{"host": "db"}}
+	if !reflect.DeepEqual(root, want) {
+		t.Errorf("%+v != %+v", root, want)
+	}
+	if len(nested) != 2 {
+		t.Error("existing values modified")
+	}
+}
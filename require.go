@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// RequirementTypes are the types which a required value may be checked for.
+var RequirementTypes = []string{"string", "int", "float", "bool", "map", "list"}
+
+// Requirement describes a value which must be set in the context. Path is
+// parsed by ParsePath. The value may be required to be of Type, to match
+// Pattern, or to be one of Values. Description is included in errors to
+// explain what the value is for.
+type Requirement struct {
+	Path        string
+	Type        string
+	Pattern     string
+	Values      []interface{}
+	Description string
+	elements    []interface{}
+	pattern     *regexp.Regexp
+}
+
+// UnmarshalYAML accepts either a full requirement or a description.
+func (r *Requirement) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var description string
+	if err := unmarshal(&description); err == nil {
+		r.Description = description
+		return nil
+	}
+	type plain Requirement
+	return unmarshal((*plain)(r))
+}
+
+// Validate parses the path and pattern and checks the type.
+func (r *Requirement) Validate() error {
+	elements, err := ParsePath(r.Path)
+	if err != nil {
+		return err
+	}
+	if r.Type != "" {
+		known := false
+		for _, typ := range RequirementTypes {
+			known = known || r.Type == typ
+		}
+		if !known {
+			return fmt.Errorf("unknown type %s for required value %s", r.Type, r.Path)
+		}
+	}
+	if r.Pattern != "" {
+		if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern for required value %s: %s", r.Path, err)
+		}
+	}
+	r.elements = elements
+	return nil
+}
+
+// Check returns an error describing why the value in `context` does not meet
+// the requirement or nil if it does. A nil value is missing.
+func (r *Requirement) Check(context map[string]interface{}) error {
+	wrapError := func(format string, v ...interface{}) error {
+		message := r.Path + " " + fmt.Sprintf(format, v...)
+		if r.Description != "" {
+			message += " (" + r.Description + ")"
+		}
+		return errors.New(message)
+	}
+
+	value, ok := GetPath(context, r.elements)
+	if !ok || value == nil {
+		return wrapError("is required")
+	}
+	if r.Type != "" && !isType(r.Type, value) {
+		return wrapError("must be of type %s", r.Type)
+	}
+	if r.pattern != nil && !r.pattern.MatchString(fmt.Sprint(value)) {
+		return wrapError("must match %s", r.Pattern)
+	}
+	if len(r.Values) > 0 {
+		allowed := make([]string, len(r.Values))
+		found := false
+		for n, allowedValue := range r.Values {
+			allowed[n] = fmt.Sprint(allowedValue)
+			found = found || allowed[n] == fmt.Sprint(value)
+		}
+		if !found {
+			return wrapError("must be one of %s", strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// isType returns true if `value` is of the named requirement type. Whole
+// floats are ints and ints are floats.
+func isType(typ string, value interface{}) bool {
+	v := reflect.ValueOf(value)
+	switch typ {
+	case "string":
+		return v.Kind() == reflect.String
+	case "int":
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		case reflect.Float32, reflect.Float64:
+			return v.Float() == float64(int64(v.Float()))
+		}
+		return false
+	case "float":
+		return isType("int", value) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+	case "bool":
+		return v.Kind() == reflect.Bool
+	case "map":
+		_, ok := mapify(value)
+		return ok
+	case "list":
+		_, ok := arrayify(value)
+		return ok
+	}
+	return false
+}
+
+// Requirements is a list of requirements. In YAML it is a map of key path to
+// requirement.
+type Requirements []Requirement
+
+// UnmarshalYAML reads the map of key path to requirement. Requirements are
+// sorted by path and validated.
+func (r *Requirements) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	entries := map[string]Requirement{}
+	requirements := Requirements{}
+	err := unmarshalPathMap(unmarshal, &entries, func(path string) error {
+		entry := entries[path]
+		entry.Path = path
+		if err := entry.Validate(); err != nil {
+			return err
+		}
+		requirements = append(requirements, entry)
+		return nil
+	})
+	*r = requirements
+	return err
+}
+
+// Check checks each requirement against `context` and returns an error listing
+// all of those which are not met.
+func (r Requirements) Check(context map[string]interface{}) error {
+	return requirementsError(r.Failures(context))
+}
+
+// Failures checks each requirement against `context` and returns the errors
+// of those which are not met.
+func (r Requirements) Failures(context map[string]interface{}) []error {
+	failures := []error{}
+	for n := range r {
+		if err := r[n].Check(context); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	return failures
+}
+
+// requirementsError returns an error listing `failures` or nil if there are
+// none.
+func requirementsError(failures []error) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	messages := make([]string, len(failures))
+	for n, err := range failures {
+		messages[n] = err.Error()
+	}
+	return fmt.Errorf("required values are not set:\n  %s", strings.Join(messages, "\n  "))
+}
+
+// SplitEnv splits the requirements into those which are not on one of the
+// named environment variables and those which are.
+func (r Requirements) SplitEnv(names []string) (Requirements, Requirements) {
+	other, env := Requirements{}, Requirements{}
+	for _, requirement := range r {
+		isEnv := false
+		if len(requirement.elements) > 1 && requirement.elements[0] == "env" {
+			for _, name := range names {
+				isEnv = isEnv || requirement.elements[1] == name
+			}
+		}
+		if isEnv {
+			env = append(env, requirement)
+		} else {
+			other = append(other, requirement)
+		}
+	}
+	return other, env
+}
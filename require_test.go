@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRequirements(t *testing.T) {
+	yaml := `
+require:
+  env.DATABASE_URL:
+    description: URL of the database
+    pattern: ^postgres://
+  db.port:
+    type: int
+  log_level:
+    values: [debug, info, warn]
+  servers[0]: The first server
+  timeout:
+    type: float
+`
+	config := &Config{}
+	if err := config.Load([]byte(yaml)); err != nil {
+		t.Fatal(err)
+	}
+
+	context := map[string]interface{}{
+		"env":       map[string]interface{}{"DATABASE_URL": "postgres://db/app"},
+		"db":        map[interface{}]interface{}{"port": int64(5432)},
+		"log_level": "info",
+		"servers":   []interface{}{"a"},
+		"timeout":   5,
+	}
+	if err := config.Require.Check(context); err != nil {
+		t.Error(err)
+	}
+
+	context = map[string]interface{}{
+		"env":       map[string]interface{}{"DATABASE_URL": "mysql://db/app"},
+		"db":        map[string]interface{}{"port": "5432"},
+		"log_level": "trace",
+		"servers":   []interface{}{},
+		"timeout":   "5s",
+	}
+	want := "required values are not set:\n" +
+		"  db.port must be of type int\n" +
+		"  env.DATABASE_URL must match ^postgres:// (URL of the database)\n" +
+		"  log_level must be one of debug, info, warn\n" +
+		"  servers[0] is required (The first server)\n" +
+		"  timeout must be of type float"
+	if err := config.Require.Check(context); err == nil {
+		t.Error("no error")
+	} else if err.Error() != want {
+		t.Errorf("have:\n%s\nwant:\n%s", err, want)
+	}
+
+	for _, yaml := range []string{"require: {a: {type: number}}", "require: {a: {pattern: '['}}", "require: {'a..b': x}"} {
+		if err := (&Config{}).Load([]byte(yaml)); err == nil {
+			t.Errorf("%s: no error", yaml)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	yamlv2 "gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// lineNumber matches the line number which prefixes decoder type errors.
+var lineNumber = regexp.MustCompile(`^line \d+: `)
+
+// ConfigError is an error at a location in a config file.
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// ConfigErrors is a list of errors found in a config file.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, len(e))
+	for n, err := range e {
+		messages[n] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// CheckConfig checks that the YAML data matches the structure of Config.
+// Unknown keys, values of the wrong type, and values which fail validation as
+// they are unmarshalled are reported with their location in the file. Values
+// of types which unmarshal themselves may also be given as a string or, for
+// lists, as a map of values.
+func CheckConfig(file string, data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		if file == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	errs := ConfigErrors{}
+	checkNode(doc.Content[0], reflect.TypeOf(Config{}), "config", func(node *yaml.Node, format string, v ...interface{}) {
+		errs = append(errs, &ConfigError{
+			File:    file,
+			Line:    node.Line,
+			Column:  node.Column,
+			Message: fmt.Sprintf(format, v...),
+		})
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkNode checks that `node` may be unmarshalled into a value of type `typ`.
+// The name of the key holding the value is used in error messages.
+func checkNode(node *yaml.Node, typ reflect.Type, name string, report func(*yaml.Node, string, ...interface{})) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.ShortTag() == "!!null" {
+		return
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if _, ok := reflect.PtrTo(typ).MethodByName("UnmarshalYAML"); !ok {
+		checkValue(node, typ, name, report)
+		return
+	}
+
+	// Types which unmarshal themselves may validate what they read. They are
+	// decoded once their contents are checked so that those errors are
+	// reported where they occur. The entries of a list given as a map are
+	// decoded one at a time.
+	failed := false
+	reportFailed := func(node *yaml.Node, format string, v ...interface{}) {
+		failed = true
+		report(node, format, v...)
+	}
+	if typ.Kind() == reflect.Slice && node.Kind == yaml.MappingNode {
+		for _, pair := range mappingPairs(node) {
+			failed = false
+			checkNode(pair[1], typ.Elem(), pair[0].Value, reportFailed)
+			if !failed {
+				entry := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{pair[0], pair[1]}}
+				checkDecode(entry, typ, pair[0].Value, pair[0], report)
+			}
+		}
+		return
+	}
+	if node.Kind != yaml.ScalarNode {
+		checkValue(node, typ, name, reportFailed)
+	}
+	if !failed {
+		checkDecode(node, typ, name, node, report)
+	}
+}
+
+// checkValue checks that `node` has the structure of type `typ`.
+func checkValue(node *yaml.Node, typ reflect.Type, name string, report func(*yaml.Node, string, ...interface{})) {
+	switch typ.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			report(node, "%s must be a map", name)
+			return
+		}
+		fields := yamlFields(typ)
+		for _, pair := range mappingPairs(node) {
+			key := pair[0]
+			if field, ok := fields[key.Value]; ok {
+				checkNode(pair[1], field.Type, key.Value, report)
+			} else {
+				report(key, "unknown key %s in %s", key.Value, name)
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			report(node, "%s must be a map", name)
+			return
+		}
+		for _, pair := range mappingPairs(node) {
+			checkNode(pair[1], typ.Elem(), pair[0].Value, report)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			report(node, "%s must be a list", name)
+			return
+		}
+		for _, item := range node.Content {
+			checkNode(item, typ.Elem(), name, report)
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			report(node, "%s must be a string", name)
+		}
+	case reflect.Bool:
+		if !decodes(node, typ) {
+			report(node, "%s must be true or false", name)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !decodes(node, typ) {
+			report(node, "%s must be an integer", name)
+		}
+	}
+}
+
+// checkDecode decodes `node` into a value of type `typ` as the config is
+// loaded and reports any error at the location of `at`. Type errors are
+// prefixed with `name` in place of the line number given by the decoder.
+func checkDecode(node *yaml.Node, typ reflect.Type, name string, at *yaml.Node, report func(*yaml.Node, string, ...interface{})) {
+	err := decode(node, typ)
+	if typeErr, ok := err.(*yamlv2.TypeError); ok {
+		for _, message := range typeErr.Errors {
+			report(at, "%s: %s", name, lineNumber.ReplaceAllString(message, ""))
+		}
+	} else if err != nil {
+		report(at, "%s", err)
+	}
+}
+
+// mappingPairs returns the key and value nodes of a mapping. The pairs of
+// mappings merged in with `<<` are included in place of the merge key.
+func mappingPairs(node *yaml.Node) [][2]*yaml.Node {
+	pairs := [][2]*yaml.Node{}
+	for n := 1; n < len(node.Content); n += 2 {
+		key, value := node.Content[n-1], node.Content[n]
+		if key.ShortTag() != "!!merge" {
+			pairs = append(pairs, [2]*yaml.Node{key, value})
+			continue
+		}
+		merged := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			merged = value.Content
+		}
+		for _, item := range merged {
+			if item.Kind == yaml.AliasNode {
+				item = item.Alias
+			}
+			if item.Kind == yaml.MappingNode {
+				pairs = append(pairs, mappingPairs(item)...)
+			}
+		}
+	}
+	return pairs
+}
+
+// decodes returns true if the scalar `node` unmarshals into a value of type
+// `typ`.
+func decodes(node *yaml.Node, typ reflect.Type) bool {
+	return node.Kind == yaml.ScalarNode && decode(node, typ) == nil
+}
+
+// decode unmarshals `node` into a new value of type `typ`. The node is decoded
+// with the same YAML package as the config so that the check matches the load.
+func decode(node *yaml.Node, typ reflect.Type) error {
+	data, err := yaml.Marshal(expandAliases(node))
+	if err != nil {
+		return err
+	}
+	return yamlv2.Unmarshal(data, reflect.New(typ).Interface())
+}
+
+// expandAliases returns a copy of `node` with aliases replaced by the nodes
+// they refer to so that it may be decoded on its own.
+func expandAliases(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		return expandAliases(node.Alias)
+	}
+	expanded := *node
+	expanded.Anchor = ""
+	expanded.Content = make([]*yaml.Node, len(node.Content))
+	for n, child := range node.Content {
+		expanded.Content[n] = expandAliases(child)
+	}
+	return &expanded
+}
+
+// yamlFields returns the fields of a struct type by their YAML key.
+func yamlFields(typ reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for n := 0; n < typ.NumField(); n++ {
+		field := typ.Field(n)
+		if field.PkgPath != "" {
+			continue
+		}
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		} else if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		fields[key] = field
+	}
+	return fields
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	valid := `
+context:
+  anything: [goes, {here: 1}]
+templates:
+  /etc/app.conf: app.tpl
+  /etc/db.conf: &db
+    src: db.tpl
+    mode: 0600
+  /etc/replica.conf:
+    <<: *db
+    src: replica.tpl
+merge:
+  servers: append
+  users: {strategy: merge-by-key, key: id}
+context_files:
+- a.json
+- {path: b.env, key: b}
+secrets:
+  dirs: [/run/secrets]
+  max_size: 1024
+profiles:
+  prod: &prod
+    strict: true
+  staging:
+    <<: [*prod]
+    init: false
+init: true
+`
+	if err := CheckConfig("conman.yml", []byte(valid)); err != nil {
+		t.Error(err)
+	}
+
+	invalid := `
+template:
+  /etc/app.conf: app.tpl
+strict: "yes"
+env: A=1
+secrets:
+  dir: [/run/secrets]
+profiles:
+  prod: &prod
+    exec: /bin/app
+  staging:
+    <<: *prod
+merge:
+  a: bogus
+require:
+  x: {pattern: "("}
+templates:
+  /etc/app.conf:
+    src: app.tpl
+    mode: [1]
+`
+	want := "conman.yml:2:1: unknown key template in config\n" +
+		"conman.yml:4:9: strict must be true or false\n" +
+		"conman.yml:5:6: env must be a list\n" +
+		"conman.yml:7:3: unknown key dir in secrets\n" +
+		"conman.yml:10:11: exec must be a list\n" +
+		"conman.yml:10:11: exec must be a list\n" +
+		"conman.yml:14:3: unknown merge strategy bogus for a\n" +
+		"conman.yml:16:3: invalid pattern for required value x: error parsing regexp: missing closing ): `(`\n" +
+		"conman.yml:20:11: mode: cannot unmarshal !!seq into string"
+	if err := CheckConfig("conman.yml", []byte(invalid)); err == nil {
+		t.Error("no error")
+	} else if err.Error() != want {
+		t.Errorf("have:\n%s\nwant:\n%s", err, want)
+	}
+
+	if err := CheckConfig("conman.yml", []byte("a: [")); err == nil {
+		t.Error("no error")
+	}
+}
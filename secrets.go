@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	DefaultSecretsMaxSize int64 = 1024 * 1024
+)
+
+// SecretsConfig describes directories of secret files to load into the
+// context. Each file in a directory is a secret named by the file name.
+type SecretsConfig struct {
+	Dirs    []string
+	MaxSize int64 `yaml:"max_size"`
+	Base64  *bool
+}
+
+// Load reads the secret files in each directory and returns a map of file name
+// to contents with surrounding whitespace trimmed. Missing directories are
+// ignored. Hidden files, such as the `..data` link in a Kubernetes secret
+// volume, are skipped and symlinks are followed. A file larger than MaxSize
+// is an error. Contents are decoded when Base64 is true. Secrets in later
+// directories replace those of the same name in earlier ones.
+func (s *SecretsConfig) Load() (map[string]interface{}, error) {
+	maxSize := s.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultSecretsMaxSize
+	}
+
+	secrets := map[string]interface{}{}
+	for _, dir := range s.Dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			file := filepath.Join(dir, name)
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			if entry.Mode()&os.ModeSymlink != 0 {
+				if entry, err = os.Stat(file); err != nil {
+					return nil, err
+				}
+			}
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+			if entry.Size() > maxSize {
+				return nil, fmt.Errorf("%s: secret larger than %d bytes", file, maxSize)
+			}
+
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			value := strings.TrimSpace(string(data))
+			if enabled(s.Base64) {
+				if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+					value = string(decoded)
+				} else {
+					return nil, fmt.Errorf("%s: %s", file, err)
+				}
+			}
+			secrets[name] = value
+		}
+	}
+	return secrets, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSecretsLoad(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	// a docker style directory
+	docker := path.Join(tmp, "docker")
+	// a kubernetes style directory with ..data links
+	k8s := path.Join(tmp, "k8s")
+	data := path.Join(k8s, "..2024_01_01")
+	for _, dir := range []string{docker, data} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		path.Join(docker, "db_password"): "hunter2\n",
+		path.Join(docker, "api_key"):     "  abc  \n",
+		path.Join(docker, ".hidden"):     "nope",
+		path.Join(data, "api_key"):       "xyz",
+	}
+	for file, content := range files {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("..2024_01_01", path.Join(k8s, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..data/api_key", path.Join(k8s, "api_key")); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := &SecretsConfig{Dirs: []string{docker, k8s, path.Join(tmp, "nope")}}
+	want := map[string]interface{}{
+		"db_password": "hunter2",
+		"api_key":     "xyz",
+	}
+	if have, err := secrets.Load(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(have, want) {
+		t.Errorf("%+v != %+v", have, want)
+	}
+
+	// size limit
+	secrets = &SecretsConfig{Dirs: []string{docker}, MaxSize: 4}
+	if _, err := secrets.Load(); err == nil {
+		t.Error("no error")
+	}
+
+	// base64
+	encoded := path.Join(tmp, "encoded")
+	if err := os.Mkdir(encoded, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(encoded, "token"), []byte("aHVudGVyMg==\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	base64 := true
+	secrets = &SecretsConfig{Dirs: []string{encoded}, Base64: &base64}
+	want = map[string]interface{}{"token": "hunter2"}
+	if have, err := secrets.Load(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(have, want) {
+		t.Errorf("%+v != %+v", have, want)
+	}
+	secrets = &SecretsConfig{Dirs: []string{docker}, Base64: &base64}
+	if _, err := secrets.Load(); err == nil {
+		t.Error("no error")
+	}
+}
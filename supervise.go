@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ForwardSignals are the signals which are forwarded to a supervised
+	// child.
+	ForwardSignals = []os.Signal{
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGHUP,
+		syscall.SIGUSR1,
+		syscall.SIGUSR2,
+		syscall.SIGQUIT,
+	}
+
+	signalNames = map[string]syscall.Signal{
+		"HUP":   syscall.SIGHUP,
+		"INT":   syscall.SIGINT,
+		"QUIT":  syscall.SIGQUIT,
+		"KILL":  syscall.SIGKILL,
+		"USR1":  syscall.SIGUSR1,
+		"USR2":  syscall.SIGUSR2,
+		"TERM":  syscall.SIGTERM,
+		"CONT":  syscall.SIGCONT,
+		"STOP":  syscall.SIGSTOP,
+		"WINCH": syscall.SIGWINCH,
+	}
+)
+
+// Supervisor runs a child process and acts as its init. Signals are forwarded
+// to the child and orphaned processes are reaped until the child exits.
+//
+// If Reload is set it is called in place of forwarding SIGHUP to the child.
+// If Credential is set the child and any spawned processes run with it.
+type Supervisor struct {
+	Args       []string
+	Env        []string
+	Group      bool
+	Credential *syscall.Credential
+	Reload     func() error
+
+	pid     int
+	spawned map[int]string
+}
+
+// Run starts the child and waits for it to exit. The exit status of the child
+// is returned. A child killed by a signal returns 128 plus the signal number.
+func (s *Supervisor) Run() (int, error) {
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals, append(ForwardSignals, syscall.SIGCHLD)...)
+	defer signal.Stop(signals)
+
+	var err error
+	s.spawned = map[int]string{}
+	if s.pid, err = s.start(s.Args, s.Env, s.Group); err != nil {
+		return 1, err
+	}
+
+	for sig := range signals {
+		if sig == syscall.SIGCHLD {
+			if status, exited := s.reap(s.pid); exited {
+				return status, nil
+			}
+		} else if sig == syscall.SIGHUP && s.Reload != nil {
+			if err := s.Reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "reload failed: %s\n", err)
+			}
+		} else {
+			s.Signal(sig.(syscall.Signal))
+		}
+	}
+	return 1, nil
+}
+
+// Signal sends a signal to the child or its process group.
+func (s *Supervisor) Signal(sig syscall.Signal) error {
+	pid := s.pid
+	if s.Group {
+		pid = -pid
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// Spawn starts an additional process alongside the child. It is reaped by the
+// supervisor and a non-zero exit status is reported on stderr.
+func (s *Supervisor) Spawn(args, env []string) error {
+	if pid, err := s.start(args, env, false); err == nil {
+		s.spawned[pid] = args[0]
+		return nil
+	} else {
+		return err
+	}
+}
+
+// start a process and return its pid.
+func (s *Supervisor) start(args, env []string, group bool) (int, error) {
+	attr := &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   &syscall.SysProcAttr{Setpgid: group, Credential: s.Credential},
+	}
+	proc, err := os.StartProcess(args[0], args, attr)
+	if err != nil {
+		return 0, err
+	}
+	return proc.Pid, nil
+}
+
+// reap all exited processes. Returns the exit status of the child and `true`
+// if the child was reaped.
+func (s *Supervisor) reap(pid int) (int, bool) {
+	status := 0
+	exited := false
+	for {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil || wpid <= 0 {
+			break
+		}
+		if wpid == pid {
+			status = ExitStatus(ws)
+			exited = true
+		} else if name, ok := s.spawned[wpid]; ok {
+			if code := ExitStatus(ws); code != 0 {
+				fmt.Fprintf(os.Stderr, "%s: exit status %d\n", name, code)
+			}
+			delete(s.spawned, wpid)
+		}
+	}
+	return status, exited
+}
+
+// ExitStatus converts a wait status to a shell style exit status.
+func ExitStatus(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// ParseSignal parses a signal name such as `SIGHUP` or `HUP`, or a signal
+// number.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(name); err == nil {
+		return syscall.Signal(num), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %s", name)
+}
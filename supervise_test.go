@@ -0,0 +1,82 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestSupervisorExitStatus(t *testing.T) {
+	tests := []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"/bin/sh", "-c", "exit 0"}, 0},
+		{[]string{"/bin/sh", "-c", "exit 3"}, 3},
+		{[]string{"/bin/sh", "-c", "kill -TERM $$"}, 143},
+	}
+
+	for _, test := range tests {
+		s := &Supervisor{Args: test.args}
+		if have, err := s.Run(); err != nil {
+			t.Error(err)
+		} else if have != test.status {
+			t.Errorf("%v: %d != %d", test.args, have, test.status)
+		}
+	}
+}
+
+func TestSupervisorForward(t *testing.T) {
+	// the child signals its parent which should forward the signal back
+	script := `trap "exit 7" TERM; kill -TERM $PPID; while :; do sleep 0.01; done`
+	s := &Supervisor{Args: []string{"/bin/sh", "-c", script}}
+	if have, err := s.Run(); err != nil {
+		t.Error(err)
+	} else if have != 7 {
+		t.Errorf("%d != 7", have)
+	}
+}
+
+func TestSupervisorReload(t *testing.T) {
+	// a SIGHUP to the parent should call Reload rather than being forwarded
+	script := `trap "exit 9" HUP; trap "exit 7" TERM; kill -HUP $PPID; while :; do sleep 0.01; done`
+	s := &Supervisor{Args: []string{"/bin/sh", "-c", script}}
+	s.Reload = func() error {
+		return s.Signal(syscall.SIGTERM)
+	}
+	if have, err := s.Run(); err != nil {
+		t.Error(err)
+	} else if have != 7 {
+		t.Errorf("%d != 7", have)
+	}
+}
+
+func TestSupervisorStartError(t *testing.T) {
+	s := &Supervisor{Args: []string{"/nonexistent"}}
+	if _, err := s.Run(); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name string
+		sig  syscall.Signal
+	}{
+		{"HUP", syscall.SIGHUP},
+		{"SIGHUP", syscall.SIGHUP},
+		{"sigusr1", syscall.SIGUSR1},
+		{"15", syscall.SIGTERM},
+	}
+
+	for _, test := range tests {
+		if have, err := ParseSignal(test.name); err != nil {
+			t.Error(err)
+		} else if have != test.sig {
+			t.Errorf("%s: %d != %d", test.name, have, test.sig)
+		}
+	}
+
+	if _, err := ParseSignal("NOPE"); err == nil {
+		t.Error("no error")
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,46 +31,231 @@ var TemplateFuncs template.FuncMap = template.FuncMap{
 	"urlRawQuery": URLRawQuery,
 	"urlQuery":    URLQuery,
 	"urlFragment": URLFragment,
+	"indent":      Indent,
 }
 
-// Template represents a single template to be rendered by ConMan.
+// Template represents a single template to be rendered by ConMan. The mode,
+// owner, and group of the destination file are set when provided. The
+// directory mode applies to any directories created for the destination. The
+// template is parsed by its Renderer, if set, to include partials.
 type Template struct {
-	Src string
-	Dst string
+	Src      string
+	Dst      string
+	Mode     os.FileMode
+	Owner    string
+	Group    string
+	DirMode  os.FileMode
+	Renderer *Renderer
 }
 
-// Render the template.
-func (t *Template) Render(context map[string]interface{}) error {
+// Render the template. Returns `true` if the contents of the destination file
+// changed.
+func (t *Template) Render(context map[string]interface{}) (bool, error) {
 	wrapError := func(err error) error {
-		return fmt.Errorf("%s: %s", t.Src, err)
+		return fmt.Errorf("%s: %s", t.Dst, err)
+	}
+
+	// render the template
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, context); err != nil {
+		return false, err
+	}
+
+	// resolve the owner and group
+	uid, gid, err := t.ids()
+	if err != nil {
+		return false, wrapError(err)
+	}
+
+	// skip the write if the contents are unchanged
+	if current, err := ioutil.ReadFile(t.Dst); err == nil && bytes.Equal(current, buf.Bytes()) {
+		if err := SetFileAttrs(t.Dst, t.Mode, uid, gid); err != nil {
+			return false, wrapError(err)
+		}
+		return false, nil
 	}
 
 	// create the destination directory
-	dir := filepath.Dir(t.Dst)
-	if err := os.MkdirAll(dir, 0777); err != nil {
-		return fmt.Errorf("%s: %s", t.Dst, err)
+	dirMode := t.DirMode
+	if dirMode == 0 {
+		dirMode = 0777
+	}
+	if err := os.MkdirAll(filepath.Dir(t.Dst), dirMode); err != nil {
+		return false, wrapError(err)
 	}
 
-	// render the template
-	name := filepath.Base(t.Src)
-	if dest, err := os.Create(t.Dst); err == nil {
-		if tpl, err := template.New(name).Funcs(TemplateFuncs).ParseFiles(t.Src); err == nil {
-			return tpl.Execute(dest, context)
-		} else {
+	// atomically replace the destination file
+	if err := WriteFileAtomic(t.Dst, buf.Bytes(), t.Mode, uid, gid); err != nil {
+		return false, wrapError(err)
+	}
+	return true, nil
+}
+
+// Execute renders the template to `w` without writing the destination file.
+func (t *Template) Execute(w io.Writer, context map[string]interface{}) error {
+	wrapError := func(err error) error {
+		return fmt.Errorf("%s: %s", t.Src, err)
+	}
+
+	renderer := t.Renderer
+	if renderer == nil {
+		renderer = &Renderer{}
+	}
+	if tpl, err := renderer.ParseFile(t.Src); err == nil {
+		if err := tpl.Execute(w, context); err != nil {
 			return wrapError(err)
 		}
 	} else {
 		return wrapError(err)
 	}
+	return nil
+}
+
+// ids returns the uid and gid of the template's owner and group. Either is -1
+// if not set.
+func (t *Template) ids() (int, int, error) {
+	uid, gid := -1, -1
+	if t.Owner != "" {
+		if user, err := LookupUser(t.Owner); err == nil {
+			uid = user.Uid
+		} else {
+			return uid, gid, err
+		}
+	}
+	if t.Group != "" {
+		if group, err := LookupGroup(t.Group); err == nil {
+			gid = group.Gid
+		} else {
+			return uid, gid, err
+		}
+	}
+	return uid, gid, nil
+}
+
+// DirTemplates walks `srcDir` and returns a template for each file in it. The
+// destination of each template mirrors its path relative to `srcDir` under
+// `dstDir`. Only files whose name matches `glob` and ends with `suffix` are
+// included and the suffix is removed from the destination. Hidden files and
+// directories are skipped and symlinks to files are followed.
+//
+// The mode, owner, group, and directory mode of each template are copied from
+// `proto`. If the mode is zero the mode of the source file is used.
+func DirTemplates(srcDir, dstDir, glob, suffix string, proto Template) ([]*Template, error) {
+	templates := []*Template{}
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if path != srcDir && strings.HasPrefix(name, ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(path); err != nil {
+				return err
+			}
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(name, suffix) {
+			return nil
+		}
+		if glob != "" {
+			if ok, err := filepath.Match(glob, name); err != nil {
+				return err
+			} else if !ok {
+				return nil
+			}
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		tpl := proto
+		tpl.Src = path
+		tpl.Dst = filepath.Join(dstDir, strings.TrimSuffix(rel, suffix))
+		if tpl.Mode == 0 {
+			tpl.Mode = info.Mode().Perm()
+		}
+		templates = append(templates, &tpl)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// Renderer creates templates which share a set of partials and options.
+// Partials are files or globs whose templates are parsed into every template
+// the Renderer creates. Strict templates fail to render when the context is
+// missing a key.
+type Renderer struct {
+	Partials []string
+	Strict   bool
+}
+
+// New creates an empty template with the template functions and partials. The
+// template also has an `include` function which renders a named template to a
+// string.
+func (r *Renderer) New(name string) (*template.Template, error) {
+	tpl := template.New(name).Funcs(TemplateFuncs)
+	if r.Strict {
+		tpl.Option("missingkey=error")
+	}
+	tpl.Funcs(template.FuncMap{
+		"include": func(name string, data interface{}) (string, error) {
+			buf := &bytes.Buffer{}
+			err := tpl.ExecuteTemplate(buf, name, data)
+			return buf.String(), err
+		},
+	})
+
+	files := []string{}
+	for _, pattern := range r.Partials {
+		if matches, err := filepath.Glob(pattern); err != nil {
+			return nil, fmt.Errorf("%s: %s", pattern, err)
+		} else if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no partials found", pattern)
+		} else {
+			files = append(files, matches...)
+		}
+	}
+	if len(files) > 0 {
+		if _, err := tpl.ParseFiles(files...); err != nil {
+			return nil, err
+		}
+	}
+	return tpl, nil
+}
+
+// ParseFile creates a template from a template file.
+func (r *Renderer) ParseFile(file string) (*template.Template, error) {
+	tpl, err := r.New(filepath.Base(file))
+	if err != nil {
+		return nil, err
+	}
+	return tpl.ParseFiles(file)
+}
+
+// ParseString creates a template from a template string.
+func (r *Renderer) ParseString(value string) (*template.Template, error) {
+	tpl, err := r.New("string")
+	if err != nil {
+		return nil, err
+	}
+	return tpl.Parse(value)
 }
 
 // RenderString takes a template string and renders it using the provided context.
-func RenderString(value string, context map[string]interface{}) (string, error) {
+func (r *Renderer) RenderString(value string, context map[string]interface{}) (string, error) {
 	wrapError := func(err error) error {
 		return fmt.Errorf("'%s': %s", value, err)
 	}
 
-	if tpl, err := template.New("string").Funcs(TemplateFuncs).Parse(value); err == nil {
+	if tpl, err := r.ParseString(value); err == nil {
 		buf := &bytes.Buffer{}
 		if err := tpl.Execute(buf, context); err == nil {
 			return buf.String(), nil
@@ -79,3 +266,17 @@ func RenderString(value string, context map[string]interface{}) (string, error)
 		return "", wrapError(err)
 	}
 }
+
+// RenderStrings renders each of the template strings in `values` using the
+// provided context.
+func (r *Renderer) RenderStrings(values []string, context map[string]interface{}) ([]string, error) {
+	rendered := make([]string, len(values))
+	for n, value := range values {
+		if renderedValue, err := r.RenderString(value, context); err == nil {
+			rendered[n] = renderedValue
+		} else {
+			return nil, err
+		}
+	}
+	return rendered, nil
+}
@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -30,9 +32,11 @@ func TestTemplate(t *testing.T) {
 
 	want := "Hello, world!"
 	context := map[string]interface{}{"greeting": "Hello", "subject": "world"}
-	tpl := &Template{src, dest}
-	if err := tpl.Render(context); err != nil {
+	tpl := &Template{Src: src, Dst: dest}
+	if changed, err := tpl.Render(context); err != nil {
 		t.Error(err)
+	} else if !changed {
+		t.Error("not changed")
 	}
 	if have, err := ioutil.ReadFile(dest); err != nil {
 		t.Error(err)
@@ -40,6 +44,21 @@ func TestTemplate(t *testing.T) {
 		t.Errorf("'%s' != '%s'", have, want)
 	}
 
+	// render again without changes
+	if changed, err := tpl.Render(context); err != nil {
+		t.Error(err)
+	} else if changed {
+		t.Error("changed")
+	}
+
+	// render again with changes
+	context["subject"] = "you"
+	if changed, err := tpl.Render(context); err != nil {
+		t.Error(err)
+	} else if !changed {
+		t.Error("not changed")
+	}
+
 	// invalid template
 	if f, err := os.Create(src); err != nil {
 		t.Fatal(err)
@@ -49,18 +68,236 @@ func TestTemplate(t *testing.T) {
 		f.Close()
 	}
 
-	tpl = &Template{src, dest}
-	if err := tpl.Render(context); err == nil {
+	tpl = &Template{Src: src, Dst: dest}
+	if _, err := tpl.Render(context); err == nil {
+		t.Error("no error")
+	}
+
+	// the destination is untouched by the failed render
+	want = "Hello, you!"
+	if have, err := ioutil.ReadFile(dest); err != nil {
+		t.Error(err)
+	} else if string(have) != want {
+		t.Errorf("'%s' != '%s'", have, want)
+	}
+}
+
+func TestTemplateMode(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	src := path.Join(tmp, "src")
+	dest := path.Join(tmp, "dir", "dest")
+	if err := ioutil.WriteFile(src, []byte(`secret`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMode := func(file string, want os.FileMode) {
+		if info, err := os.Stat(file); err != nil {
+			t.Error(err)
+		} else if info.Mode().Perm() != want {
+			t.Errorf("%s: %o != %o", file, info.Mode().Perm(), want)
+		}
+	}
+
+	tpl := &Template{Src: src, Dst: dest, Mode: 0600, DirMode: 0750}
+	if _, err := tpl.Render(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkMode(dest, 0600)
+	checkMode(path.Dir(dest), 0750)
+
+	// the mode is applied even when the contents are unchanged
+	tpl.Mode = 0640
+	if changed, err := tpl.Render(nil); err != nil {
+		t.Fatal(err)
+	} else if changed {
+		t.Error("changed")
+	}
+	checkMode(dest, 0640)
+}
+
+func TestDirTemplates(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	srcDir := path.Join(tmp, "src")
+	dstDir := path.Join(tmp, "dst")
+	files := map[string]os.FileMode{
+		"a.conf.tpl":         0644,
+		"b.txt.tpl":          0600,
+		"c.conf":             0644,
+		".hidden.conf.tpl":   0644,
+		"sub/d.conf.tpl":     0640,
+		".git/e.conf.tpl":    0644,
+		"sub/deep/f.txt.tpl": 0644,
+	}
+	for name, mode := range files {
+		file := path.Join(srcDir, name)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(file, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.conf.tpl", path.Join(srcDir, "link.conf.tpl")); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := DirTemplates(srcDir, dstDir, "*.conf.tpl", ".tpl", Template{Owner: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Template{
+		{Src: path.Join(srcDir, "a.conf.tpl"), Dst: path.Join(dstDir, "a.conf"), Mode: 0644, Owner: "app"},
+		{Src: path.Join(srcDir, "link.conf.tpl"), Dst: path.Join(dstDir, "link.conf"), Mode: 0644, Owner: "app"},
+		{Src: path.Join(srcDir, "sub/d.conf.tpl"), Dst: path.Join(dstDir, "sub/d.conf"), Mode: 0640, Owner: "app"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Error("templates invalid")
+		for _, tpl := range have {
+			t.Errorf("  have: %+v", tpl)
+		}
+		for _, tpl := range want {
+			t.Errorf("  want: %+v", tpl)
+		}
+	}
+
+	// the mode overrides the source mode
+	have, err = DirTemplates(srcDir, dstDir, "", ".txt.tpl", Template{Mode: 0400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []*Template{
+		{Src: path.Join(srcDir, "b.txt.tpl"), Dst: path.Join(dstDir, "b"), Mode: 0400},
+		{Src: path.Join(srcDir, "sub/deep/f.txt.tpl"), Dst: path.Join(dstDir, "sub/deep/f"), Mode: 0400},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Error("templates invalid")
+		for _, tpl := range have {
+			t.Errorf("  have: %+v", tpl)
+		}
+	}
+}
+
+func TestRendererPartials(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	partials := path.Join(tmp, "partials")
+	if err := os.Mkdir(partials, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		path.Join(partials, "tls.tpl"):  `{{ define "tls" }}ssl on;{{ "\n" }}ssl_cert {{ .cert }};{{ end }}`,
+		path.Join(partials, "name.tpl"): `{{ define "name" }}{{ .name }}{{ end }}`,
+		path.Join(tmp, "src"):           "server {\n{{ include \"tls\" . | indent 2 }}\n}",
+	}
+	for file, content := range files {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	context := map[string]interface{}{"cert": "a.pem", "name": "test"}
+	renderer := &Renderer{Partials: []string{path.Join(partials, "*.tpl")}}
+
+	// templates
+	dest := path.Join(tmp, "dest")
+	tpl := &Template{Src: path.Join(tmp, "src"), Dst: dest, Renderer: renderer}
+	if _, err := tpl.Render(context); err != nil {
+		t.Fatal(err)
+	}
+	want := "server {\n  ssl on;\n  ssl_cert a.pem;\n}"
+	if have, err := ioutil.ReadFile(dest); err != nil {
+		t.Error(err)
+	} else if string(have) != want {
+		t.Errorf("'%s' != '%s'", have, want)
+	}
+
+	// strings
+	want = "hello test"
+	if have, err := renderer.RenderString(`hello {{ template "name" . }}`, context); err != nil {
+		t.Error(err)
+	} else if have != want {
+		t.Errorf("'%s' != '%s'", have, want)
+	}
+
+	// missing partials
+	renderer = &Renderer{Partials: []string{path.Join(tmp, "nope", "*.tpl")}}
+	if _, err := renderer.RenderString("", context); err == nil {
 		t.Error("no error")
 	}
 }
 
+func TestRendererStrict(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	partial := path.Join(tmp, "partial")
+	src := path.Join(tmp, "src")
+	if err := ioutil.WriteFile(partial, []byte(`{{ define "db" }}{{ .env.DATABSE_URL }}{{ end }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(src, []byte("url\n{{ .env.DATABSE_URL }}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	context := map[string]interface{}{"env": map[string]interface{}{"DATABASE_URL": "db"}}
+	renderer := &Renderer{Partials: []string{partial}, Strict: true}
+
+	tpl := &Template{Src: src, Dst: path.Join(tmp, "dest"), Renderer: renderer}
+	if _, err := tpl.Render(context); err == nil {
+		t.Error("no error")
+	} else if msg := err.Error(); !strings.HasPrefix(msg, src+": ") || !strings.Contains(msg, ":2:") || !strings.Contains(msg, ".env.DATABSE_URL") {
+		t.Errorf("invalid error: %s", msg)
+	}
+
+	for _, value := range []string{`{{ .env.DATABSE_URL }}`, `{{ template "db" . }}`, `{{ include "db" . }}`} {
+		if _, err := renderer.RenderString(value, context); err == nil {
+			t.Errorf("no error: %s", value)
+		}
+	}
+
+	// not strict
+	renderer.Strict = false
+	if _, err := renderer.RenderString(`{{ .env.DATABSE_URL }}`, context); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestRenderString(t *testing.T) {
+	renderer := &Renderer{}
+
 	// no substitutions
 	context := map[string]interface{}{}
 	tpl := "Hello, world!"
 	want := "Hello, world!"
-	if have, err := RenderString(tpl, context); err != nil {
+	if have, err := renderer.RenderString(tpl, context); err != nil {
 		t.Error(err)
 	} else if have != want {
 		t.Errorf("'%s' != '%s'", have, want)
@@ -70,7 +307,7 @@ func TestRenderString(t *testing.T) {
 	context = map[string]interface{}{"greeting": "Hello", "subject": "world"}
 	tpl = "{{ .greeting }}, {{ .subject }}!"
 	want = "Hello, world!"
-	if have, err := RenderString(tpl, context); err != nil {
+	if have, err := renderer.RenderString(tpl, context); err != nil {
 		t.Error(err)
 	} else if have != want {
 		t.Errorf("'%s' != '%s'", have, want)
@@ -79,7 +316,7 @@ func TestRenderString(t *testing.T) {
 	// invalid template
 	context = map[string]interface{}{}
 	tpl = "{{ Oops!"
-	if _, err := RenderString(tpl, context); err == nil {
+	if _, err := renderer.RenderString(tpl, context); err == nil {
 		t.Error("no error")
 	} else {
 		t.Log(err)
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	PasswdFile = "/etc/passwd"
+	GroupFile  = "/etc/group"
+)
+
+// User is an entry in the passwd file.
+type User struct {
+	Name string
+	Uid  int
+	Gid  int
+	Home string
+}
+
+// Group is an entry in the group file.
+type Group struct {
+	Name    string
+	Gid     int
+	Members []string
+}
+
+// Identity is the user, primary group, and supplementary groups a process
+// runs as.
+type Identity struct {
+	User   *User
+	Gid    int
+	Groups []int
+}
+
+// LookupIdentity resolves a user, and optionally a group and supplementary
+// groups, to an identity. The user's primary group is used if `group` is
+// empty. If `groups` is nil the supplementary groups are those which list the
+// user as a member along with the primary group.
+func LookupIdentity(user, group string, groups []string) (*Identity, error) {
+	id := &Identity{}
+	if u, err := LookupUser(user); err == nil {
+		id.User = u
+		id.Gid = u.Gid
+	} else {
+		return nil, err
+	}
+	if group != "" {
+		if g, err := LookupGroup(group); err == nil {
+			id.Gid = g.Gid
+		} else {
+			return nil, err
+		}
+	}
+
+	if groups == nil {
+		id.Groups = []int{id.Gid}
+		err := readColonFile(GroupFile, func(fields []string) bool {
+			if len(fields) < 4 {
+				return false
+			}
+			gid, err := strconv.Atoi(fields[2])
+			if err != nil || gid == id.Gid {
+				return false
+			}
+			for _, member := range splitMembers(fields[3]) {
+				if member == id.User.Name {
+					id.Groups = append(id.Groups, gid)
+					break
+				}
+			}
+			return false
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		id.Groups = make([]int, 0, len(groups))
+		for _, name := range groups {
+			if g, err := LookupGroup(name); err == nil {
+				id.Groups = append(id.Groups, g.Gid)
+			} else {
+				return nil, err
+			}
+		}
+	}
+	return id, nil
+}
+
+// Credential returns the identity as a credential for starting a process.
+func (id *Identity) Credential() *syscall.Credential {
+	groups := make([]uint32, len(id.Groups))
+	for n, gid := range id.Groups {
+		groups[n] = uint32(gid)
+	}
+	return &syscall.Credential{
+		Uid:    uint32(id.User.Uid),
+		Gid:    uint32(id.Gid),
+		Groups: groups,
+	}
+}
+
+// Apply sets the groups, gid, and uid of the current process to those of the
+// identity. This is irreversible when dropping root privileges.
+func (id *Identity) Apply() error {
+	if err := syscall.Setgroups(id.Groups); err != nil {
+		return fmt.Errorf("setgroups: %s", err)
+	}
+	if err := syscall.Setgid(id.Gid); err != nil {
+		return fmt.Errorf("setgid: %s", err)
+	}
+	if err := syscall.Setuid(id.User.Uid); err != nil {
+		return fmt.Errorf("setuid: %s", err)
+	}
+	return nil
+}
+
+// Environ returns the HOME and USER environment variables for the identity.
+func (id *Identity) Environ() map[string]string {
+	return map[string]string{
+		"HOME": id.User.Home,
+		"USER": id.User.Name,
+	}
+}
+
+// LookupUser finds a user by name or uid in the passwd file. A numeric uid
+// which is not in the passwd file results in a user with a matching gid and a
+// home directory of `/`.
+func LookupUser(name string) (*User, error) {
+	var user *User
+	err := readColonFile(PasswdFile, func(fields []string) bool {
+		if len(fields) < 6 || (fields[0] != name && fields[2] != name) {
+			return false
+		}
+		uid, uidErr := strconv.Atoi(fields[2])
+		gid, gidErr := strconv.Atoi(fields[3])
+		if uidErr != nil || gidErr != nil {
+			return false
+		}
+		user = &User{Name: fields[0], Uid: uid, Gid: gid, Home: fields[5]}
+		return true
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if user == nil {
+		if uid, err := strconv.Atoi(name); err == nil && uid >= 0 {
+			user = &User{Name: name, Uid: uid, Gid: uid, Home: "/"}
+		} else {
+			return nil, fmt.Errorf("unknown user %s", name)
+		}
+	}
+	return user, nil
+}
+
+// LookupGroup finds a group by name or gid in the group file. A numeric gid
+// which is not in the group file results in a group with no members.
+func LookupGroup(name string) (*Group, error) {
+	var group *Group
+	err := readColonFile(GroupFile, func(fields []string) bool {
+		if len(fields) < 4 || (fields[0] != name && fields[2] != name) {
+			return false
+		}
+		if gid, err := strconv.Atoi(fields[2]); err == nil {
+			group = &Group{Name: fields[0], Gid: gid, Members: splitMembers(fields[3])}
+			return true
+		}
+		return false
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if group == nil {
+		if gid, err := strconv.Atoi(name); err == nil && gid >= 0 {
+			group = &Group{Name: name, Gid: gid}
+		} else {
+			return nil, fmt.Errorf("unknown group %s", name)
+		}
+	}
+	return group, nil
+}
+
+// readColonFile calls `fn` with the fields of each line in a colon delimited
+// file such as /etc/passwd until it returns `true`.
+func readColonFile(file string, fn func([]string) bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fn(strings.Split(line, ":")) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+func splitMembers(members string) []string {
+	if members == "" {
+		return []string{}
+	}
+	return strings.Split(members, ",")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func withUserFiles(t *testing.T, passwd, group string, fn func()) {
+	tmp, err := ioutil.TempDir("", "conman_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmp)
+	}()
+
+	oldPasswd, oldGroup := PasswdFile, GroupFile
+	PasswdFile, GroupFile = path.Join(tmp, "passwd"), path.Join(tmp, "group")
+	defer func() {
+		PasswdFile, GroupFile = oldPasswd, oldGroup
+	}()
+
+	if err := ioutil.WriteFile(PasswdFile, []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(GroupFile, []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fn()
+}
+
+const (
+	testPasswd = `root:x:0:0:root:/root:/bin/sh
+app:x:1000:1000:App:/home/app:/bin/sh
+`
+	testGroup = `root:x:0:
+app:x:1000:
+web:x:33:app,www
+`
+)
+
+func TestLookupUser(t *testing.T) {
+	withUserFiles(t, testPasswd, testGroup, func() {
+		tests := []struct {
+			name string
+			want *User
+		}{
+			{"root", &User{Name: "root", Uid: 0, Gid: 0, Home: "/root"}},
+			{"app", &User{Name: "app", Uid: 1000, Gid: 1000, Home: "/home/app"}},
+			{"1000", &User{Name: "app", Uid: 1000, Gid: 1000, Home: "/home/app"}},
+			{"2000", &User{Name: "2000", Uid: 2000, Gid: 2000, Home: "/"}},
+		}
+		for _, test := range tests {
+			if have, err := LookupUser(test.name); err != nil {
+				t.Error(err)
+			} else if !reflect.DeepEqual(have, test.want) {
+				t.Errorf("%+v != %+v", have, test.want)
+			}
+		}
+		if _, err := LookupUser("nobody"); err == nil {
+			t.Error("no error")
+		}
+	})
+}
+
+func TestLookupGroup(t *testing.T) {
+	withUserFiles(t, testPasswd, testGroup, func() {
+		tests := []struct {
+			name string
+			want *Group
+		}{
+			{"app", &Group{Name: "app", Gid: 1000, Members: []string{}}},
+			{"33", &Group{Name: "web", Gid: 33, Members: []string{"app", "www"}}},
+			{"2000", &Group{Name: "2000", Gid: 2000}},
+		}
+		for _, test := range tests {
+			if have, err := LookupGroup(test.name); err != nil {
+				t.Error(err)
+			} else if !reflect.DeepEqual(have, test.want) {
+				t.Errorf("%+v != %+v", have, test.want)
+			}
+		}
+		if _, err := LookupGroup("nogroup"); err == nil {
+			t.Error("no error")
+		}
+	})
+}
+
+func TestLookupIdentity(t *testing.T) {
+	withUserFiles(t, testPasswd, testGroup, func() {
+		tests := []struct {
+			user   string
+			group  string
+			groups []string
+			want   *Identity
+		}{
+			{
+				"app", "", nil,
+				&Identity{User: &User{Name: "app", Uid: 1000, Gid: 1000, Home: "/home/app"}, Gid: 1000, Groups: []int{1000, 33}},
+			},
+			{
+				"app", "web", nil,
+				&Identity{User: &User{Name: "app", Uid: 1000, Gid: 1000, Home: "/home/app"}, Gid: 33, Groups: []int{33}},
+			},
+			{
+				"app", "", []string{"root", "2000"},
+				&Identity{User: &User{Name: "app", Uid: 1000, Gid: 1000, Home: "/home/app"}, Gid: 1000, Groups: []int{0, 2000}},
+			},
+			{
+				"2000", "", []string{},
+				&Identity{User: &User{Name: "2000", Uid: 2000, Gid: 2000, Home: "/"}, Gid: 2000, Groups: []int{}},
+			},
+		}
+		for _, test := range tests {
+			if have, err := LookupIdentity(test.user, test.group, test.groups); err != nil {
+				t.Error(err)
+			} else if !reflect.DeepEqual(have, test.want) {
+				t.Errorf("%+v != %+v", have, test.want)
+			}
+		}
+		if _, err := LookupIdentity("app", "nogroup", nil); err == nil {
+			t.Error("no error")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ValueTypes are the types which a string value may be explicitly converted
+// to by ConvertValue.
+var ValueTypes = []string{"string", "int", "float", "bool", "null", "json"}
+
+// ConvertValue converts a string value to the named type.
+func ConvertValue(typ, value string) (interface{}, error) {
+	wrapError := func(err error) error {
+		return fmt.Errorf("invalid %s value '%s': %s", typ, value, err)
+	}
+
+	switch typ {
+	case "string":
+		return value, nil
+	case "int":
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i, nil
+		} else {
+			return nil, wrapError(err)
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f, nil
+		} else {
+			return nil, wrapError(err)
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b, nil
+		} else {
+			return nil, wrapError(err)
+		}
+	case "null":
+		if value != "" && value != "null" {
+			return nil, wrapError(fmt.Errorf("must be empty or null"))
+		}
+		return nil, nil
+	case "json":
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err == nil {
+			return v, nil
+		} else {
+			return nil, wrapError(err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %s", typ)
+	}
+}
+
+// IsValueType returns true if `typ` is one of ValueTypes.
+func IsValueType(typ string) bool {
+	for _, valueType := range ValueTypes {
+		if typ == valueType {
+			return true
+		}
+	}
+	return false
+}
+
+// InferValue infers the type of a string value. The strings `true` and `false`
+// become booleans, `null` becomes nil, decimal numbers become ints or floats,
+// and JSON objects and arrays are parsed. Numbers with leading zeros remain
+// strings. Any other value is returned unchanged.
+func InferValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if isNumber(value) {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err == nil {
+			return v
+		}
+	}
+	return value
+}
+
+// InferValues returns a copy of `values` with InferValue applied to each
+// string in it, including those in nested maps and arrays.
+func InferValues(values map[string]interface{}) map[string]interface{} {
+	inferred := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		inferred[key] = inferAny(value)
+	}
+	return inferred
+}
+
+func inferAny(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return InferValue(v)
+	case map[string]interface{}:
+		return InferValues(v)
+	case []interface{}:
+		inferred := make([]interface{}, len(v))
+		for n, item := range v {
+			inferred[n] = inferAny(item)
+		}
+		return inferred
+	default:
+		return value
+	}
+}
+
+// isNumber returns true if the value is a decimal number without leading zeros.
+func isNumber(value string) bool {
+	digits := strings.TrimPrefix(value, "-")
+	if digits == "" || digits[0] < '0' || digits[0] > '9' {
+		return false
+	}
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return false
+	}
+	for _, c := range digits {
+		if (c < '0' || c > '9') && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertValue(t *testing.T) {
+	tests := []struct {
+		typ   string
+		value string
+		want  interface{}
+	}{
+		{"string", "5", "5"},
+		{"int", "5", int64(5)},
+		{"int", "-12", int64(-12)},
+		{"float", "1.5", 1.5},
+		{"bool", "true", true},
+		{"bool", "false", false},
+		{"null", "", nil},
+		{"json", `[1, "a"]`, []interface{}{1.0, "a"}},
+		{"json", `{"a": true}`, map[string]interface{}{"a": true}},
+	}
+
+	for _, test := range tests {
+		if have, err := ConvertValue(test.typ, test.value); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(have, test.want) {
+			t.Errorf("%s %s: %#v != %#v", test.typ, test.value, have, test.want)
+		}
+	}
+
+	for _, test := range [][2]string{
+		{"int", "five"},
+		{"float", "x"},
+		{"bool", "yes please"},
+		{"null", "x"},
+		{"json", "{"},
+		{"nope", "x"},
+	} {
+		if _, err := ConvertValue(test[0], test[1]); err == nil {
+			t.Errorf("%s %s: no error", test[0], test[1])
+		}
+	}
+}
+
+func TestInferValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  interface{}
+	}{
+		{"", ""},
+		{"hello", "hello"},
+		{"true", true},
+		{"false", false},
+		{"True", "True"},
+		{"null", nil},
+		{"0", int64(0)},
+		{"5432", int64(5432)},
+		{"-1", int64(-1)},
+		{"0.5", 0.5},
+		{"1e3", 1000.0},
+		{"007", "007"},
+		{"1.2.3", "1.2.3"},
+		{"inf", "inf"},
+		{"[1]", []interface{}{1.0}},
+		{`{"a":"b"}`, map[string]interface{}{"a": "b"}},
+		{"{nope", "{nope"},
+	}
+
+	for _, test := range tests {
+		have := InferValue(test.value)
+		if !reflect.DeepEqual(have, test.want) {
+			t.Errorf("%s: %#v != %#v", test.value, have, test.want)
+		}
+	}
+}
+
+func TestInferValues(t *testing.T) {
+	in := map[string]interface{}{
+		"a": "1",
+		"b": map[string]interface{}{"c": "true", "d": []interface{}{"null", "x"}},
+		"e": 2,
+	}
+	want := map[string]interface{}{
+		"a": int64(1),
+		"b": map[string]interface{}{"c": true, "d": []interface{}{nil, "x"}},
+		"e": 2,
+	}
+	have := InferValues(in)
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("%#v != %#v", have, want)
+	}
+	if in["a"] != "1" {
+		t.Error("input modified")
+	}
+}